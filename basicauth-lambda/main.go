@@ -2,39 +2,123 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
-	"net/http"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/cloudfork-com/moneypenny-aws-controls/internal/mac"
 )
 
 var Version string = "dev"
 
+// users is kept between invocations of a warm function; plain passwords are hashed once.
+var users *mac.Users
+
 func main() {
 	lambda.Start(HandleRequest)
 }
 
-func HandleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	resp := events.APIGatewayProxyResponse{
-		Headers:    map[string]string{"Content-Type": "text/html; charset=UTF-8"},
-		StatusCode: http.StatusOK}
+// authorizerRequest is the part of both payload formats of an API Gateway Lambda authorizer request used here.
+type authorizerRequest struct {
+	Version   string            `json:"version"`
+	MethodArn string            `json:"methodArn"` // payload format 1.0 and REST APIs
+	RouteArn  string            `json:"routeArn"`  // payload format 2.0
+	Headers   map[string]string `json:"headers"`
+}
+
+// errUnauthorized makes API Gateway respond with 401.
+// API Gateway does not add a WWW-Authenticate header, so a Browser only asks for credentials
+// if a REST API has a gateway response for UNAUTHORIZED with that header.
+var errUnauthorized = errors.New("Unauthorized")
 
-	// https://stackoverflow.com/questions/58037317/getting-x-amzn-remapped-www-authenticate-instead-of-www-authenticate-and-jetty
-	// auth check
-	if len(req.Headers) == 0 {
-		slog.Info("function is not invoked from public APIGateway so no user credentials check needed")
+// HandleRequest checks the basic credentials of the Authorization header and
+// returns an IAM policy or, with AUTHORIZER_RESPONSE=simple and payload format 2.0, the simple response.
+// A denied simple response makes API Gateway respond with 403 instead of 401.
+// The context of an allowed request has the user, role and clusters for the controls function.
+func HandleRequest(ctx context.Context, payload json.RawMessage) (any, error) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)).With("v", Version))
+	req := authorizerRequest{}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+	if err := loadUsers(); err != nil {
+		slog.Error("failed to load users", "err", err)
+		return nil, errUnauthorized
+	}
+	authorization := ""
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, "Authorization") {
+			authorization = v
+		}
+	}
+	user, ok := users.AuthenticateBasic(authorization)
+	if !ok {
+		slog.Warn("invalid credentials", "has-authorization", authorization != "")
 	} else {
-		httpreq, _ := http.NewRequest(http.MethodGet, "/", nil)
-		httpreq.Header.Add("Authorization", req.Headers["authorization"]) // must be lowercase
-		user, pass, ok := httpreq.BasicAuth()
-		if !ok || user != os.Getenv("BASIC_USER") || pass != os.Getenv("BASIC_PASSWORD") {
-			slog.Warn("invalid credentials", "user-length", len(user), "pass-length", len(pass), "request-headers", req.Headers)
-			resp.Headers["WWW-Authenticate"] = `Basic realm="Restricted"`
-			resp.StatusCode = http.StatusUnauthorized
-			return resp, nil
+		slog.Info("authorized", "user", user.Name, "role", user.Role)
+	}
+	simple := req.Version == "2.0" && os.Getenv("AUTHORIZER_RESPONSE") == "simple"
+	if simple {
+		response := events.APIGatewayV2CustomAuthorizerSimpleResponse{IsAuthorized: ok}
+		if ok {
+			response.Context = mac.AuthorizerContext(user)
+		}
+		return response, nil
+	}
+	if !ok {
+		return nil, errUnauthorized
+	}
+	arn := req.MethodArn
+	if arn == "" {
+		arn = req.RouteArn
+	}
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID:    user.Name,
+		PolicyDocument: allowPolicy(arn),
+		Context:        mac.AuthorizerContext(user),
+	}, nil
+}
+
+// allowPolicy allows invoking all routes of the stage of the arn, such that a cached policy
+// is valid for each route and not only for the one that was requested first.
+func allowPolicy(arn string) events.APIGatewayCustomAuthorizerPolicy {
+	// arn:aws:execute-api:region:account:api-id/stage/METHOD/path
+	resource := arn
+	if parts := strings.SplitN(arn, "/", 3); len(parts) == 3 {
+		resource = parts[0] + "/" + parts[1] + "/*"
+	}
+	return events.APIGatewayCustomAuthorizerPolicy{
+		Version: "2012-10-17",
+		Statement: []events.IAMPolicyStatement{{
+			Action:   []string{"execute-api:Invoke"},
+			Effect:   "Allow",
+			Resource: []string{resource},
+		}},
+	}
+}
+
+// loadUsers reads USERS, or else the single admin BASIC_USER with BASIC_PASSWORD_HASH (bcrypt) or BASIC_PASSWORD.
+func loadUsers() (err error) {
+	if users != nil {
+		return nil
+	}
+	if source := os.Getenv("USERS"); source != "" {
+		users, err = mac.LoadUsers(source)
+		return err
+	}
+	hash := os.Getenv("BASIC_PASSWORD_HASH")
+	if hash == "" {
+		if os.Getenv("BASIC_PASSWORD") == "" {
+			return errors.New("missing USERS, BASIC_PASSWORD_HASH or BASIC_PASSWORD")
+		}
+		if hash, err = mac.HashPassword(os.Getenv("BASIC_PASSWORD")); err != nil {
+			return err
 		}
 	}
-	return resp, nil
+	users, err = mac.SingleUser(os.Getenv("BASIC_USER"), hash)
+	return err
 }
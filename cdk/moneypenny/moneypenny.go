@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2authorizers"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2integrations"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
@@ -83,6 +89,64 @@ func NewMoneypennyStack(scope constructs.Construct, id string, props *Moneypenny
 		),
 		Resources: jsii.Strings("*"),
 	}))
+	environment := map[string]*string{
+		"TIME_ZONE": jsii.String("Europe/Amsterdam"),
+	}
+	// the secret of the tokens of posted actions is required, e.g. cdk deploy -c csrf-secret=$(openssl rand -hex 32)
+	if secret, ok := stack.Node().TryGetContext(jsii.String("csrf-secret")).(string); ok && len(secret) >= 16 {
+		environment["CSRF_SECRET"] = jsii.String(secret)
+	} else {
+		awscdk.Annotations_Of(stack).AddError(jsii.String("context csrf-secret of at least 16 characters is required, e.g. cdk deploy -c csrf-secret=$(openssl rand -hex 32)"))
+	}
+	// credentials of the status page, never as plain text because the environment is readable and in the template:
+	// a users document, e.g. cdk deploy -c users=ssm://moneypenny/users,
+	// or one admin with a bcrypt hash, e.g. cdk deploy -c basic-user=moneypenny -c basic-password-hash=$(echo -n ... | awscontrols hash-password)
+	var usersStatement awsiam.PolicyStatement
+	if source, ok := stack.Node().TryGetContext(jsii.String("users")).(string); ok && source != "" {
+		environment["USERS"] = jsii.String(source)
+		if usersStatement = planSourceStatement(stack, source); usersStatement != nil {
+			role.AddToPolicy(usersStatement)
+		} else {
+			awscdk.Annotations_Of(stack).AddError(jsii.String("context users must be an ssm:// parameter or s3:// object"))
+		}
+	}
+	if user, ok := stack.Node().TryGetContext(jsii.String("basic-user")).(string); ok && user != "" {
+		environment["BASIC_USER"] = jsii.String(user)
+		hash, _ := stack.Node().TryGetContext(jsii.String("basic-password-hash")).(string)
+		environment["BASIC_PASSWORD_HASH"] = jsii.String(hash)
+	}
+	if _, ok := stack.Node().TryGetContext(jsii.String("basic-password")).(string); ok {
+		awscdk.Annotations_Of(stack).AddError(jsii.String("context basic-password is not supported, use basic-password-hash or users"))
+	}
+	// optional service plans document, e.g. cdk deploy -c plans=ssm://moneypenny/plans
+	if plans, ok := stack.Node().TryGetContext(jsii.String("plans")).(string); ok && plans != "" {
+		environment["PLANS"] = jsii.String(plans)
+		if statement := planSourceStatement(stack, plans); statement != nil {
+			role.AddToPolicy(statement)
+		}
+	}
+	// optional exception dates on which no service is running, e.g. cdk deploy -c except=2026-12-25,2026-12-26
+	if dates, ok := stack.Node().TryGetContext(jsii.String("except")).(string); ok && dates != "" {
+		environment["EXCEPT"] = jsii.String(dates)
+	}
+	// optional state store, e.g. cdk deploy -c state=dynamodb://moneypenny-audit
+	if spec, ok := stack.Node().TryGetContext(jsii.String("state")).(string); ok && spec != "" {
+		environment["STATE"] = jsii.String(spec)
+		if table, ok := strings.CutPrefix(spec, "dynamodb://"); ok {
+			role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Effect:    awsiam.Effect_ALLOW,
+				Actions:   jsii.Strings("dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:DeleteItem"),
+				Resources: jsii.Strings(fmt.Sprintf("arn:%s:dynamodb:%s:%s:table/%s", *stack.Partition(), *stack.Region(), *stack.Account(), table)),
+			}))
+		}
+	}
+	// optional audit store, e.g. cdk deploy -c audit=dynamodb://moneypenny-audit
+	if audit, ok := stack.Node().TryGetContext(jsii.String("audit")).(string); ok && audit != "" {
+		environment["AUDIT"] = jsii.String(audit)
+		if statement := auditStoreStatement(stack, audit); statement != nil {
+			role.AddToPolicy(statement)
+		}
+	}
 
 	// Add a managed policy to a role you can use
 	// role.AddManagedPolicy(awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonECS_FullAccess")))
@@ -96,11 +160,9 @@ func NewMoneypennyStack(scope constructs.Construct, id string, props *Moneypenny
 		Architecture: awslambda.Architecture_ARM_64(),
 		Role:         role,
 		Description:  jsii.String("Moneypenny AWS Controls - Lambda function to control the desired count of ECS services"),
-		Environment: &map[string]*string{
-			"Variables": jsii.String("{TIME_ZONE=Europe/Amsterdam,BASIC_USER=nil,BASIC_PASSWORD=zork}"),
-		},
-		MemorySize: jsii.Number(128),
-		Timeout:    awscdk.Duration_Seconds(jsii.Number(10)),
+		Environment:  &environment,
+		MemorySize:   jsii.Number(128),
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(10)),
 		CurrentVersionOptions: &awslambda.VersionOptions{
 			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
 			RetryAttempts: jsii.Number(1),
@@ -108,6 +170,18 @@ func NewMoneypennyStack(scope constructs.Construct, id string, props *Moneypenny
 		LogRetention: awslogs.RetentionDays_FIVE_DAYS,
 	})
 
+	// Apply the schedule 5 minutes past every hour; the Scheduled Event of the rule has no detail so it applies to all services.
+	// Use the detail of an event to choose the action and filter the services, e.g. {"action":"apply","cluster":"dev"}.
+	awsevents.NewRule(stack, jsii.String("moneypenny-aws-controls-apply"), &awsevents.RuleProps{
+		Description: jsii.String("Moneypenny AWS Controls - apply the schedule of all services"),
+		Schedule:    awsevents.Schedule_Cron(&awsevents.CronOptions{Minute: jsii.String("5")}),
+		Targets: &[]awsevents.IRuleTarget{
+			awseventstargets.NewLambdaFunction(controlsLambda, &awseventstargets.LambdaFunctionProps{
+				RetryAttempts: jsii.Number(1),
+			}),
+		},
+	})
+
 	// Add protected URL
 	// TODO needed when using API Gateway ???
 	controlsLambda.AddFunctionUrl(&awslambda.FunctionUrlOptions{
@@ -125,11 +199,13 @@ func NewMoneypennyStack(scope constructs.Construct, id string, props *Moneypenny
 		CreateDefaultStage: jsii.Bool(true),
 	})
 
-	// Create a Lambda integration
+	// Create a Lambda integration; the function handles the API Gateway proxy request of payload format 1.0
 	lambdaIntegration := awsapigatewayv2integrations.NewHttpLambdaIntegration(
 		jsii.String("moneypenny-aws-controls-integration"),
 		controlsLambda,
-		&awsapigatewayv2integrations.HttpLambdaIntegrationProps{})
+		&awsapigatewayv2integrations.HttpLambdaIntegrationProps{
+			PayloadFormatVersion: awsapigatewayv2.PayloadFormatVersion_VERSION_1_0(),
+		})
 
 	awsapigatewayv2.NewHttpRoute(stack, jsii.String("moneypenny-aws-controls-get"), &awsapigatewayv2.HttpRouteProps{
 		HttpApi:     httpApi,
@@ -141,5 +217,97 @@ func NewMoneypennyStack(scope constructs.Construct, id string, props *Moneypenny
 		Integration: lambdaIntegration,
 		RouteKey:    awsapigatewayv2.HttpRouteKey_With(jsii.String("/"), awsapigatewayv2.HttpMethod_POST),
 	})
+	// OIDC login
+	awsapigatewayv2.NewHttpRoute(stack, jsii.String("moneypenny-aws-controls-oidc-callback"), &awsapigatewayv2.HttpRouteProps{
+		HttpApi:     httpApi,
+		Integration: lambdaIntegration,
+		RouteKey:    awsapigatewayv2.HttpRouteKey_With(jsii.String("/oidc/callback"), awsapigatewayv2.HttpMethod_GET),
+	})
+	// JSON API, optionally checked by the basicauth-lambda authorizer, e.g. cdk deploy -c authorizer=basic
+	var apiAuthorizer awsapigatewayv2.IHttpRouteAuthorizer
+	if kind, ok := stack.Node().TryGetContext(jsii.String("authorizer")).(string); ok && kind == "basic" {
+		apiAuthorizer = basicAuthorizer(stack, environment, usersStatement)
+	}
+	awsapigatewayv2.NewHttpRoute(stack, jsii.String("moneypenny-aws-controls-api-get"), &awsapigatewayv2.HttpRouteProps{
+		HttpApi:     httpApi,
+		Integration: lambdaIntegration,
+		RouteKey:    awsapigatewayv2.HttpRouteKey_With(jsii.String("/api/{proxy+}"), awsapigatewayv2.HttpMethod_GET),
+		Authorizer:  apiAuthorizer,
+	})
+	awsapigatewayv2.NewHttpRoute(stack, jsii.String("moneypenny-aws-controls-api-post"), &awsapigatewayv2.HttpRouteProps{
+		HttpApi:     httpApi,
+		Integration: lambdaIntegration,
+		RouteKey:    awsapigatewayv2.HttpRouteKey_With(jsii.String("/api/{proxy+}"), awsapigatewayv2.HttpMethod_POST),
+		Authorizer:  apiAuthorizer,
+	})
 	return stack
 }
+
+// basicAuthorizer returns the basicauth-lambda as authorizer with IAM policy responses, cached per Authorization header.
+// It checks the same users document or password hash as the controls function; usersStatement, if not nil, allows reading that document.
+// It is not used for the pages because API Gateway responds to a denied request without WWW-Authenticate, so the Browser would not ask for credentials.
+func basicAuthorizer(stack awscdk.Stack, controlsEnvironment map[string]*string, usersStatement awsiam.PolicyStatement) awsapigatewayv2.IHttpRouteAuthorizer {
+	environment := map[string]*string{}
+	for _, each := range []string{"USERS", "BASIC_USER", "BASIC_PASSWORD_HASH"} {
+		if v, ok := controlsEnvironment[each]; ok {
+			environment[each] = v
+		}
+	}
+	authorizerLambda := awslambda.NewFunction(stack, jsii.String("moneypenny-aws-controls-basicauth"), &awslambda.FunctionProps{
+		Code:         awslambda.Code_FromAsset(jsii.String("../../basicauth-lambda"), &awss3assets.AssetOptions{}), // folder where bootstrap executable is located
+		Runtime:      awslambda.Runtime_PROVIDED_AL2023(),
+		Handler:      jsii.String("bootstrap"),
+		Architecture: awslambda.Architecture_ARM_64(),
+		Description:  jsii.String("Moneypenny AWS Controls - Lambda authorizer that checks basic credentials"),
+		Environment:  &environment,
+		MemorySize:   jsii.Number(128),
+		Timeout:      awscdk.Duration_Seconds(jsii.Number(10)),
+		LogRetention: awslogs.RetentionDays_FIVE_DAYS,
+	})
+	if usersStatement != nil {
+		authorizerLambda.AddToRolePolicy(usersStatement)
+	}
+	return awsapigatewayv2authorizers.NewHttpLambdaAuthorizer(jsii.String("moneypenny-aws-controls-basicauth-authorizer"), authorizerLambda, &awsapigatewayv2authorizers.HttpLambdaAuthorizerProps{
+		ResponseTypes:   &[]awsapigatewayv2authorizers.HttpLambdaResponseType{awsapigatewayv2authorizers.HttpLambdaResponseType_IAM},
+		IdentitySource:  jsii.Strings("$request.header.Authorization"),
+		ResultsCacheTtl: awscdk.Duration_Seconds(jsii.Number(300)),
+	})
+}
+
+// planSourceStatement allows reading the plans or users of an ssm:// parameter or s3:// object only; nil for other sources.
+func planSourceStatement(stack awscdk.Stack, source string) awsiam.PolicyStatement {
+	if name, ok := strings.CutPrefix(source, "ssm://"); ok {
+		return awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   jsii.Strings("ssm:GetParameter"),
+			Resources: jsii.Strings(fmt.Sprintf("arn:%s:ssm:%s:%s:parameter/%s", *stack.Partition(), *stack.Region(), *stack.Account(), strings.TrimPrefix(name, "/"))),
+		})
+	}
+	if object, ok := strings.CutPrefix(source, "s3://"); ok {
+		return awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   jsii.Strings("s3:GetObject"),
+			Resources: jsii.Strings(fmt.Sprintf("arn:%s:s3:::%s", *stack.Partition(), object)),
+		})
+	}
+	return nil
+}
+
+// auditStoreStatement allows writing and querying the audit of a dynamodb:// table or logs:// log group only; nil for other stores.
+func auditStoreStatement(stack awscdk.Stack, store string) awsiam.PolicyStatement {
+	if table, ok := strings.CutPrefix(store, "dynamodb://"); ok {
+		return awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   jsii.Strings("dynamodb:PutItem", "dynamodb:Query"),
+			Resources: jsii.Strings(fmt.Sprintf("arn:%s:dynamodb:%s:%s:table/%s", *stack.Partition(), *stack.Region(), *stack.Account(), table)),
+		})
+	}
+	if group, ok := strings.CutPrefix(store, "logs://"); ok {
+		return awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   jsii.Strings("logs:CreateLogStream", "logs:PutLogEvents", "logs:FilterLogEvents"),
+			Resources: jsii.Strings(fmt.Sprintf("arn:%s:logs:%s:%s:log-group:%s:*", *stack.Partition(), *stack.Region(), *stack.Account(), group)),
+		})
+	}
+	return nil
+}
@@ -1,50 +1,291 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"slices"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/cloudfork-com/moneypenny-aws-controls/internal/mac"
 	"github.com/lmittmann/tint"
 )
 
-var plansInput = flag.String("plans", "", "description of service plans")
+var Version string = "dev"
+
+var plansInput = flag.String("plans", "", "description of service plans: file, file://, ssm://path/to/param or s3://bucket/key")
 
 var isDebug = flag.Bool("debug", false, "if true then more logging")
 
 var localOnly = flag.Bool("local", false, "if true then only use the local service plans file")
 
+var notifyTargets = flag.String("notify", "", "comma separated webhooks told about applied changes, e.g. slack=https://hooks.slack.com/services/...; kinds are json, slack and teams")
+
+var notifyTemplate = flag.String("notify-template", "", "file with the text/template of the notification message")
+
+var statusURL = flag.String("status-url", "", "URL of the status page, used for snooze links in stop warnings")
+
+var auditSpec = flag.String("audit", "", "audit store of all actions: file.jsonl, file://, dynamodb://table or logs://log-group")
+
+var stateSpec = flag.String("state", "", "store of the state of services across runs, such as snoozes: file.json, file:// or dynamodb://table; in memory if empty")
+
+var deploymentsMode = flag.String("deployments", mac.DeploymentsDefer, "what to do with a scheduled stop during a deployment: defer or proceed")
+
+var deploymentGrace = flag.Duration("deployment-grace", mac.DefaultDeploymentGrace, "how long a stop is deferred at most for a deployment in progress")
+
+var exceptDates = flag.String("except", "", "comma separated dates on which no service is running, e.g. holidays: 2026-12-25,2026-12-26")
+
+var metricsOutput = flag.String("metrics", "", "if set, write the metrics in Prometheus text format to this file after the run")
+
 func main() {
 	flag.Parse()
 	setupLog()
 
 	slog.Info("awscontrols - scheduling ECS services")
-	loader := mac.NewPlanLoader(*plansInput)
-	if err := loader.LoadServicePlans(); err != nil {
+	command := flag.Arg(0)
+	if command == "schema" {
+		os.Stdout.Write(mac.PlansSchema)
 		return
 	}
-	client, err := mac.NewECSClient()
+	if command == "hash-password" {
+		hashPassword()
+		return
+	}
+	isValidate := command == "validate"
+	client, plans, err := loadPlans()
 	if err != nil {
+		if isValidate {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
 	}
-	fetcher := mac.NewPlanFetcher(client)
-	if err := fetcher.CheckServicePlans(loader.Plans); err != nil {
+	if isValidate {
+		os.Exit(validate(plans))
+	}
+	executor := mac.NewPlanExecutor(client, plans)
+	exceptions, err := mac.ParseExceptionDates(*exceptDates)
+	if err != nil {
+		slog.Error("invalid except flag", "err", err)
 		return
 	}
-	executor := mac.NewPlanExecutor(client, loader.Plans)
-
-	if slices.Contains(os.Args, "apply") {
+	executor.SetExceptions(exceptions)
+	notifiers, err := mac.ParseNotifiers(*notifyTargets, *notifyTemplate)
+	if err != nil {
+		slog.Error("invalid notify flags", "err", err)
+		return
+	}
+	for _, each := range notifiers {
+		executor.AddNotifier(each)
+	}
+	executor.SetStatusURL(*statusURL)
+	policy, err := mac.ParseDeploymentPolicy(*deploymentsMode, deploymentGrace.String())
+	if err != nil {
+		slog.Error("invalid deployments flags", "err", err)
+		return
+	}
+	executor.SetDeploymentPolicy(policy)
+	if *auditSpec != "" {
+		store, err := mac.NewAuditStore(*auditSpec)
+		if err != nil {
+			slog.Error("invalid audit flag", "err", err)
+			return
+		}
+		who, err := mac.CallerIdentity()
+		if err != nil {
+			slog.Warn("unable to get caller identity", "err", err)
+			who = os.Getenv("USER")
+		}
+		executor.SetAudit(store, who)
+	}
+	if *stateSpec != "" {
+		store, err := mac.NewStateStore(*stateSpec)
+		if err != nil {
+			slog.Error("invalid state flag", "err", err)
+			return
+		}
+		executor.SetStateStore(store)
+	} else if command == "apply" || command == "serve" || command == "drift" {
+		slog.Warn("no -state store set, snoozes and deferred stops are not kept after this run")
+	}
+	switch command {
+	case "audit":
+		audit(executor)
+		return
+	case "serve":
+		serve(executor)
+		return
+	case "apply":
 		executor.Apply()
-	} else if slices.Contains(os.Args, "report") {
+	case "report":
 		executor.Report()
-	} else if slices.Contains(os.Args, "schedule") {
+	case "schedule":
 		executor.Schedule()
-	} else {
+	case "timeline":
+		executor.Timeline()
+	case "drift":
+		drift(executor)
+	case "export":
+		export(executor)
+	default:
 		executor.Plan()
 	}
+	if *metricsOutput != "" {
+		writeMetrics(executor, *metricsOutput)
+	}
+}
+
+// hashPassword reads a password from stdin and prints its hash for the users document.
+func hashPassword() {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		slog.Error("failed to read password", "err", err)
+		return
+	}
+	hash, err := mac.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		slog.Error("failed to hash password", "err", err)
+		return
+	}
+	fmt.Println(hash)
+}
+
+// writeMetrics writes the metrics collected by the executor to a file.
+func writeMetrics(executor *mac.PlanExecutor, name string) {
+	out, err := os.Create(name)
+	if err != nil {
+		slog.Error("failed to create metrics file", "file", name, "err", err)
+		return
+	}
+	defer out.Close()
+	if err := executor.Metrics().WriteOn(out); err != nil {
+		slog.Error("failed to write metrics", "file", name, "err", err)
+	}
+}
+
+// export writes the schedule in the format given by the export flags.
+func export(executor *mac.PlanExecutor) {
+	exportFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := exportFlags.String("format", "ics", "export format: ics or svg")
+	cluster := exportFlags.String("cluster", "", "if set, only services of this cluster name")
+	service := exportFlags.String("service", "", "if set, only services with this name")
+	exportFlags.Parse(flag.Args()[1:])
+	switch *format {
+	case "ics":
+		executor.ExportICS(*cluster, *service)
+	case "svg":
+		executor.Timeline()
+	default:
+		slog.Error("unknown export format", "format", *format)
+	}
+}
+
+// drift prints the services of which the actual state differs from the schedule.
+func drift(executor *mac.PlanExecutor) {
+	driftFlags := flag.NewFlagSet("drift", flag.ExitOnError)
+	asJSON := driftFlags.Bool("json", false, "if true then print the drifts as JSON")
+	correctOlderThan := driftFlags.Duration("correct-older-than", 0, "if set, correct drifts that last at least this long, e.g. 30m")
+	driftFlags.Parse(flag.Args()[1:])
+	drifts, err := executor.Drift(*correctOlderThan)
+	if err != nil {
+		slog.Error("drift detection failed", "err", err)
+		return
+	}
+	if *asJSON {
+		mac.NewReporter(executor).WriteDriftOn(os.Stdout, drifts)
+		return
+	}
+	for _, each := range drifts {
+		corrected := ""
+		if each.Corrected {
+			corrected = ", corrected"
+		}
+		if each.Note != "" {
+			corrected += ", " + each.Note
+		}
+		fmt.Printf("%s/%s: %s%s\n", each.ClusterName(), each.Name(), each, corrected)
+	}
+}
+
+// audit prints the recent entries of the audit store as JSON lines.
+func audit(executor *mac.PlanExecutor) {
+	auditFlags := flag.NewFlagSet("audit", flag.ExitOnError)
+	filter := mac.AuditFilter{}
+	auditFlags.StringVar(&filter.Service, "service", "", "if set, only entries of this service name or ARN")
+	auditFlags.StringVar(&filter.Action, "action", "", "if set, only entries of this action")
+	auditFlags.StringVar(&filter.Who, "who", "", "if set, only entries of this user or IAM principal")
+	auditFlags.StringVar(&filter.Source, "source", "", "if set, only entries of this source: schedule, override or manual")
+	limit := auditFlags.Int("limit", 100, "maximum number of entries")
+	auditFlags.Parse(flag.Args()[1:])
+	if executor.AuditStore() == nil {
+		slog.Error("audit requires the -audit flag")
+		return
+	}
+	entries, err := executor.AuditStore().Recent(context.Background(), filter, *limit)
+	if err != nil {
+		slog.Error("failed to read audit", "err", err)
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, each := range entries {
+		enc.Encode(each)
+	}
+}
+
+// loadPlans returns the local plans merged with the tag plans, unless localOnly.
+func loadPlans() (*ecs.Client, []*mac.ServicePlan, error) {
+	loader := mac.NewPlanLoader(*plansInput)
+	if err := loader.LoadServicePlans(); err != nil {
+		return nil, nil, err
+	}
+	client, err := mac.NewECSClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	fetcher := mac.NewPlanFetcher(client)
+	if *localOnly {
+		local, err := fetcher.ExpandServicePlans(loader.Plans)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := fetcher.CheckServicePlans(local); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if err := fetcher.FetchServicePlans(); err != nil {
+			return nil, nil, err
+		}
+		local, err := fetcher.ExpandServicePlans(loader.Plans)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := fetcher.MergeLocalServicePlans(local); err != nil {
+			return nil, nil, err
+		}
+	}
+	return client, fetcher.Plans, nil
+}
+
+// validate prints the diagnostics of all plans and returns the exit code.
+func validate(plans []*mac.ServicePlan) int {
+	errorCount := 0
+	for _, each := range mac.LintServicePlans(plans) {
+		fmt.Println(each)
+		if each.Severity == mac.SeverityError {
+			errorCount++
+		}
+	}
+	slog.Info("validated service plans", "count", len(plans), "errors", errorCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
 }
 
 func setupLog() {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfork-com/moneypenny-aws-controls/internal/mac"
+)
+
+// serve runs an HTTP server with the controls UI, the same as the AWS Lambda, and a /metrics route for Prometheus.
+// As with the AWS Lambda, the service plans are fetched again for each request.
+// A scrape runs the plan (dry-run) to refresh the service metrics if the previous one is older than -metrics-max-age.
+// The /metrics route requires the same authentication as the controls, unless it is served on its own -metrics-addr.
+// With OIDC_ISSUER, the controls UI requires a bearer token or login of the identity provider and authorizes actions by the roles of groups.
+// Otherwise, with -users (or USERS), the controls UI requires basic auth of one of the users and authorizes actions by role.
+// Otherwise, if BASIC_USER is set then the controls UI requires basic auth with BASIC_USER and BASIC_PASSWORD.
+// Without any of these, the server refuses to start unless -insecure is given.
+// Actions are posted with a token of CSRF_SECRET, random if not set.
+func serve(executor *mac.PlanExecutor) {
+	serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := serveFlags.String("addr", "localhost:9090", "listen address of the HTTP server, e.g. :9090 for all interfaces")
+	insecure := serveFlags.Bool("insecure", false, "if true then serve the controls without authentication if none is configured")
+	usersSource := serveFlags.String("users", os.Getenv("USERS"), "users document with roles: file, file://, ssm://path/to/param or s3://bucket/key")
+	metricsAddr := serveFlags.String("metrics-addr", "", "if set, serve /metrics without authentication on this listen address only, e.g. localhost:9091")
+	metricsMaxAge := serveFlags.Duration("metrics-max-age", time.Minute, "how long a scrape reuses the metrics of the previous plan")
+	serveFlags.Parse(flag.Args()[1:])
+
+	auth, err := serveAuth(*usersSource)
+	if err != nil {
+		slog.Error("failed to set up authentication", "err", err)
+		return
+	}
+	if auth == nil {
+		if !*insecure {
+			slog.Error("no authentication configured, set OIDC_ISSUER, USERS or BASIC_USER, or use -insecure")
+			return
+		}
+		slog.Warn("serving the controls without authentication, anyone who can reach the address can change services", "addr", *addr)
+	}
+	// fetch the plans again, such that changes of tags and plans are used without a restart
+	current := func() (*mac.PlanExecutor, error) {
+		client, plans, err := loadPlans()
+		if err != nil {
+			return nil, err
+		}
+		return executor.WithPlans(client, plans), nil
+	}
+	var mutex sync.Mutex
+	metrics := newMetricsHandler(current, &mutex, *metricsMaxAge)
+	if *metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics)
+		go func() {
+			slog.Info("serving metrics", "addr", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, metricsMux); err != nil {
+				slog.Error("serve metrics failed", "err", err)
+			}
+		}()
+		metrics = nil
+	}
+	mux, err := newServeMux(current, &mutex, auth, metrics)
+	if err != nil {
+		slog.Error("failed to set up controls", "err", err)
+		return
+	}
+	slog.Info("serving controls and metrics", "addr", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		slog.Error("serve failed", "err", err)
+	}
+}
+
+// serveAuth returns the authentication of the controls: OIDC, the users document or basic auth; nil if none.
+func serveAuth(usersSource string) (func(http.Handler) http.Handler, error) {
+	config, ok, err := mac.OIDCConfigFromEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return mac.NewOIDC(config).Authenticate, nil
+	}
+	if usersSource != "" {
+		users, err := mac.LoadUsers(usersSource)
+		if err != nil {
+			return nil, err
+		}
+		return func(next http.Handler) http.Handler { return mac.UsersAuth(users, next) }, nil
+	}
+	if user := os.Getenv("BASIC_USER"); user != "" {
+		return func(next http.Handler) http.Handler { return mac.BasicAuth(user, os.Getenv("BASIC_PASSWORD"), next) }, nil
+	}
+	return nil, nil
+}
+
+// newMetricsHandler returns the handler of /metrics that runs the plan at most once per maxAge.
+func newMetricsHandler(current func() (*mac.PlanExecutor, error), mutex *sync.Mutex, maxAge time.Duration) http.Handler {
+	var planned time.Time
+	var metrics *mac.Metrics
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if metrics == nil || time.Since(planned) >= maxAge {
+			executor, err := current()
+			if err != nil {
+				slog.Error("failed to fetch plans", "err", err)
+				http.Error(w, "failed to fetch plans", http.StatusInternalServerError)
+				return
+			}
+			if err := executor.Plan(); err != nil {
+				slog.Error("plan failed", "err", err)
+			}
+			metrics, planned = executor.Metrics(), time.Now()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := metrics.WriteOn(w); err != nil {
+			slog.Error("failed to write metrics", "err", err)
+		}
+	})
+}
+
+// newServeMux returns the routes of the serve command, with an executor of the current plans per request guarded by mutex.
+// The controls and metrics, if not nil, are wrapped by auth if not nil.
+func newServeMux(current func() (*mac.PlanExecutor, error), mutex *sync.Mutex, auth func(http.Handler) http.Handler, metrics http.Handler) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+	if metrics != nil {
+		if auth != nil {
+			metrics = auth(metrics)
+		}
+		mux.Handle("/metrics", metrics)
+	}
+	handler := mac.NewControlsHandler(Version, func(r *http.Request) (*mac.PlanExecutor, error) {
+		executor, err := current()
+		if err != nil {
+			return nil, err
+		}
+		if store := executor.AuditStore(); store != nil {
+			if who := mac.UserFrom(r.Context()); who != "" {
+				executor.SetAudit(store, who)
+			}
+		}
+		return executor, nil
+	})
+	handler.SetLogHandler(slog.Default().Handler())
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		if err := handler.SetCSRFSecret(secret); err != nil {
+			return nil, err
+		}
+	}
+	var controls http.Handler = handler
+	if auth != nil {
+		controls = auth(controls)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		controls.ServeHTTP(w, r)
+	})
+	return mux, nil
+}
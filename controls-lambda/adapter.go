@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// newHTTPRequest returns the net/http request for an API Gateway proxy request.
+func newHTTPRequest(ctx context.Context, req events.APIGatewayProxyRequest) (*http.Request, error) {
+	method := req.HTTPMethod
+	if method == "" { // not invoked through API Gateway
+		method = http.MethodGet
+	}
+	path := req.Path
+	if path == "" {
+		path = "/"
+	}
+	query := url.Values{}
+	for k, vs := range req.MultiValueQueryStringParameters {
+		query[k] = vs
+	}
+	for k, v := range req.QueryStringParameters {
+		if _, ok := query[k]; !ok {
+			query.Set(k, v)
+		}
+	}
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	httpreq, err := http.NewRequestWithContext(ctx, method, (&url.URL{Path: path, RawQuery: query.Encode()}).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range req.MultiValueHeaders {
+		for _, each := range vs {
+			httpreq.Header.Add(k, each)
+		}
+	}
+	for k, v := range req.Headers {
+		if httpreq.Header.Get(k) == "" {
+			httpreq.Header.Set(k, v)
+		}
+	}
+	return httpreq, nil
+}
+
+// newHTTPRequestV2 returns the net/http request for an API Gateway proxy request of payload format 2.0.
+func newHTTPRequestV2(ctx context.Context, req events.APIGatewayV2HTTPRequest) (*http.Request, error) {
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	path := req.RawPath
+	if path == "" {
+		path = "/"
+	}
+	httpreq, err := http.NewRequestWithContext(ctx, req.RequestContext.HTTP.Method, (&url.URL{Path: path, RawQuery: req.RawQueryString}).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Headers {
+		httpreq.Header.Set(k, v)
+	}
+	if len(req.Cookies) > 0 {
+		httpreq.Header.Set("Cookie", strings.Join(req.Cookies, "; "))
+	}
+	return httpreq, nil
+}
+
+// proxyResponseWriter collects the response of a handler for API Gateway.
+type proxyResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newProxyResponseWriter() *proxyResponseWriter {
+	return &proxyResponseWriter{header: http.Header{}}
+}
+
+func (w *proxyResponseWriter) Header() http.Header { return w.header }
+
+func (w *proxyResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *proxyResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// responseV2 returns the API Gateway proxy response of payload format 2.0 with what was written.
+// Headers with multiple values are joined, except for cookies which have their own field.
+func (w *proxyResponseWriter) responseV2() events.APIGatewayV2HTTPResponse {
+	v1 := w.response()
+	resp := events.APIGatewayV2HTTPResponse{
+		StatusCode:      v1.StatusCode,
+		Headers:         v1.Headers,
+		Body:            v1.Body,
+		IsBase64Encoded: v1.IsBase64Encoded,
+		Cookies:         w.header.Values("Set-Cookie"),
+	}
+	delete(resp.Headers, "Set-Cookie")
+	for k, vs := range v1.MultiValueHeaders {
+		if k != "Set-Cookie" {
+			resp.Headers[k] = strings.Join(vs, ",")
+		}
+	}
+	return resp
+}
+
+// response returns the API Gateway proxy response with what was written.
+func (w *proxyResponseWriter) response() events.APIGatewayProxyResponse {
+	resp := events.APIGatewayProxyResponse{
+		StatusCode:        w.status,
+		Headers:           map[string]string{},
+		MultiValueHeaders: map[string][]string{},
+		Body:              w.body.String(),
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	for k, vs := range w.header {
+		if len(vs) == 1 {
+			resp.Headers[k] = vs[0]
+		} else {
+			resp.MultiValueHeaders[k] = vs
+		}
+	}
+	if ct := resp.Headers["Content-Type"]; ct != "" && !strings.HasPrefix(ct, "text/") && !strings.HasPrefix(ct, "application/json") {
+		resp.Body = base64.StdEncoding.EncodeToString(w.body.Bytes())
+		resp.IsBase64Encoded = true
+	}
+	return resp
+}
@@ -1,17 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/emicklei/htmlslog"
-
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/cloudfork-com/moneypenny-aws-controls/internal/mac"
@@ -19,164 +16,251 @@ import (
 
 var Version string = "dev"
 
+// oidc is kept between invocations of a warm function to reuse the fetched keys of the identity provider.
+var oidc *mac.OIDC
+
+// state is kept between invocations of a warm function; without STATE it is lost when the function instance ends.
+var state mac.StateStore
+
 func main() {
 	lambda.Start(HandleRequest)
 }
 
-func HandleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	resp := events.APIGatewayProxyResponse{
-		Headers:    map[string]string{"Content-Type": "text/html; charset=UTF-8"},
-		StatusCode: http.StatusOK}
+// HandleRequest serves an EventBridge event or an API Gateway proxy request of payload format 1.0 or 2.0.
+// Any other invocation is handled as a proxy request and requires credentials as well.
+func HandleRequest(ctx context.Context, payload json.RawMessage) (any, error) {
+	if action, source, ok, err := mac.ParseScheduledEvent(payload); ok {
+		return handleScheduledEvent(action, source, err)
+	}
+	kind := struct {
+		Version string `json:"version"`
+	}{}
+	json.Unmarshal(payload, &kind)
+	if kind.Version == "2.0" {
+		req := events.APIGatewayV2HTTPRequest{}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		httpreq, err := newHTTPRequestV2(ctx, req)
+		if err != nil {
+			return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+		}
+		var authorizer map[string]any
+		if req.RequestContext.Authorizer != nil {
+			authorizer = req.RequestContext.Authorizer.Lambda
+		}
+		w := newProxyResponseWriter()
+		serve(w, httpreq, authorizer, "")
+		return w.responseV2(), nil
+	}
+	req := events.APIGatewayProxyRequest{}
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+	}
+	httpreq, err := newHTTPRequest(ctx, req)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: err.Error()}, nil
+	}
+	// the context of a Lambda authorizer is nested for HTTP APIs and flat for REST APIs
+	authorizer := req.RequestContext.Authorizer
+	if nested, ok := authorizer["lambda"].(map[string]any); ok {
+		authorizer = nested
+	}
+	w := newProxyResponseWriter()
+	serve(w, httpreq, authorizer, req.RequestContext.Identity.UserArn)
+	return w.response(), nil
+}
 
+// serve handles the request with the controls.
+// A user in the context of a Lambda authorizer is trusted, else the credentials are checked here.
+func serve(w http.ResponseWriter, httpreq *http.Request, authorizer map[string]any, callerARN string) {
+	setup(logLevel(httpreq))
+	controls := mac.NewControlsHandler(Version, func(r *http.Request) (*mac.PlanExecutor, error) {
+		return newExecutor(func() string { return auditActor(mac.UserFrom(r.Context()), callerARN) })
+	})
+	controls.SetLogHandler(slog.Default().Handler())
+	// tokens of posted actions must be valid for all instances of the function
+	if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+		if err := controls.SetCSRFSecret(secret); err != nil {
+			slog.Error("invalid CSRF_SECRET", "err", err)
+			http.Error(w, "unable to check posted actions", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		slog.Error("CSRF_SECRET is required")
+		http.Error(w, "unable to check posted actions", http.StatusInternalServerError)
+		return
+	}
+	var handler http.Handler = controls
 	// https://stackoverflow.com/questions/58037317/getting-x-amzn-remapped-www-authenticate-instead-of-www-authenticate-and-jetty
 	// auth check
-	if len(req.Headers) == 0 {
-		slog.Info("function is not invoked from public APIGateway so no user credentials check needed")
-	} else {
-		httpreq, _ := http.NewRequest(http.MethodGet, "/", nil)
-		httpreq.Header.Add("Authorization", req.Headers["authorization"]) // must be lowercase
-		user, pass, ok := httpreq.BasicAuth()
-		if !ok || user != os.Getenv("BASIC_USER") || pass != os.Getenv("BASIC_PASSWORD") {
-			slog.Warn("invalid credentials", "user-length", len(user), "pass-length", len(pass), "request-headers", req.Headers)
-			resp.Headers["WWW-Authenticate"] = `Basic realm="Restricted"`
-			resp.StatusCode = http.StatusUnauthorized
-			return resp, nil
+	if user, ok := mac.UserFromAuthorizerContext(authorizer); ok {
+		slog.Debug("user checked by authorizer", "user", user.Name, "role", user.Role)
+		httpreq = httpreq.WithContext(mac.WithAccount(httpreq.Context(), user))
+	} else if config, ok, err := mac.OIDCConfigFromEnv(os.Getenv); ok {
+		if err != nil {
+			slog.Error("invalid oidc config", "err", err)
+			http.Error(w, "unable to check credentials", http.StatusInternalServerError)
+			return
+		}
+		if oidc == nil {
+			oidc = mac.NewOIDC(config)
 		}
+		handler = oidc.Authenticate(handler)
+	} else if source := os.Getenv("USERS"); source != "" {
+		users, err := mac.LoadUsers(source)
+		if err != nil {
+			slog.Error("failed to load users", "source", source, "err", err)
+			http.Error(w, "unable to check credentials", http.StatusInternalServerError)
+			return
+		}
+		handler = mac.UsersAuth(users, handler)
+	} else if user, hash := os.Getenv("BASIC_USER"), os.Getenv("BASIC_PASSWORD_HASH"); user != "" && hash != "" {
+		users, err := mac.SingleUser(user, hash)
+		if err != nil {
+			slog.Error("invalid BASIC_PASSWORD_HASH", "err", err)
+			http.Error(w, "unable to check credentials", http.StatusInternalServerError)
+			return
+		}
+		handler = mac.UsersAuth(users, handler)
+	} else if user, password := os.Getenv("BASIC_USER"), os.Getenv("BASIC_PASSWORD"); user != "" && password != "" {
+		handler = mac.BasicAuth(user, password, handler)
+	} else {
+		slog.Error("no authentication configured, set OIDC_ISSUER, USERS or BASIC_USER and BASIC_PASSWORD_HASH")
+		http.Error(w, "unable to check credentials", http.StatusInternalServerError)
+		return
 	}
+	handler.ServeHTTP(w, httpreq)
+}
 
-	// setup logging
-	isDebug := req.QueryStringParameters["debug"] == "true"
-	logLevel := slog.LevelInfo
-	if isDebug {
-		logLevel = slog.LevelDebug
-	}
-	logBuffer := new(bytes.Buffer)
-	stdoutHandler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel, ReplaceAttr: removeTimeAndLevel})
-	logHandler := htmlslog.New(logBuffer, htmlslog.Options{
-		Title:              "moneypenny-aws-controls",
-		TimeLayout:         time.RFC3339,
-		Level:              logLevel,
-		PassthroughHandler: stdoutHandler,
-		TableOnly:          true})
-	slog.SetDefault(slog.New(logHandler).With("v", Version))
-	// dump available environment variables
-	if isDebug {
-		for _, each := range os.Environ() {
-			slog.Debug("env", "entry", each)
-		}
+// handleScheduledEvent performs the action of an EventBridge event and returns its result.
+// An invalid event or a failure to get the plans fails the invocation, so that it shows in the metrics of the rule or schedule.
+func handleScheduledEvent(action mac.ScheduledAction, source string, parseErr error) (any, error) {
+	setup(slog.LevelInfo)
+	if parseErr != nil {
+		slog.Error("invalid scheduled event", "source", source, "err", parseErr)
+		return nil, parseErr
+	}
+	slog.Info("handling scheduled event", "source", source, "action", action.Action, "filter", action.Filter().Query().Encode())
+	executor, err := newExecutor(func() string { return auditActor("", "") })
+	if err != nil {
+		slog.Error("failed to create executor", "err", err)
+		return nil, err
+	}
+	result := action.Run(executor)
+	result.Source = source
+	// fail the invocation, so that it shows in the metrics of the rule or schedule and is retried
+	if result.Error != "" {
+		return result, errors.New(result.Error)
 	}
-	// timezone setup
+	return result, nil
+}
+
+// setup sets the default logger and the timezone of each invocation.
+func setup(level slog.Level) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level, ReplaceAttr: removeTimeAndLevel})).With("v", Version))
 	if err := mac.SetTimezone(os.Getenv("TIME_ZONE")); err != nil {
 		slog.Warn("failed to set timezone, using local", "err", err, "local", time.Local.String(), "TIME_ZONE", os.Getenv("TIME_ZONE"))
 	}
+}
 
-	// setup client
+// newExecutor returns an executor for the tag plans merged with the optional local plans, bundled with the function.
+// who is only called when auditing.
+func newExecutor(who func() string) (*mac.PlanExecutor, error) {
 	client, err := mac.NewECSClient()
 	if err != nil {
-		return resp, err
+		return nil, err
 	}
 	fetcher := mac.NewPlanFetcher(client)
 	if err := fetcher.FetchServicePlans(); err != nil {
-		return resp, err
+		return nil, err
+	}
+	loader := mac.NewPlanLoader(os.Getenv("PLANS"))
+	if err := loader.LoadServicePlans(); err != nil {
+		return nil, err
+	}
+	local, err := fetcher.ExpandServicePlans(loader.Plans)
+	if err != nil {
+		return nil, err
+	}
+	if err := fetcher.MergeLocalServicePlans(local); err != nil {
+		return nil, err
 	}
 
 	executor := mac.NewPlanExecutor(client, fetcher.Plans)
-	rep := mac.NewReporter(executor)
-	action := req.QueryStringParameters["do"]
-	switch action {
-	case "apply":
-		executor.Apply()
-		// wait to allow state change
-		time.Sleep(1 * time.Second)
-		logHandler.Close()
-		resp.Body = wrapLog(logBuffer.String(), rep)
-		return resp, nil
-	case "start":
-		executor.Start(req.QueryStringParameters["service-arn"])
-		// wait to allow state change
-		time.Sleep(1 * time.Second)
-		logHandler.Close()
-		resp.Body = wrapLog(logBuffer.String(), rep)
-		return resp, nil
-	case "stop":
-		executor.Stop(req.QueryStringParameters["service-arn"])
-		// wait to allow state change
-		time.Sleep(1 * time.Second)
-		logHandler.Close()
-		resp.Body = wrapLog(logBuffer.String(), rep)
-		return resp, nil
-	case "plan":
-		executor.Plan()
-		logHandler.Close()
-		resp.Body = wrapLog(logBuffer.String(), rep)
-		return resp, nil
-	case "change-count":
-		// wait to allow state change
-		time.Sleep(1 * time.Second)
-		executor.ChangeTaskCount(req.QueryStringParameters["service-arn"], req.QueryStringParameters["count"])
-		logHandler.Close()
-		resp.Body = wrapLog(logBuffer.String(), rep)
-		return resp, nil
-	}
-	html := new(bytes.Buffer)
-	rep.WriteOpenHTMLOn(html)
-
-	if err := rep.WriteControlsOn(html); err != nil {
-		logHandler.Close()
-		resp.StatusCode = 500
-		resp.Body = logBuffer.String()
-		return resp, err
-	}
-
-	fmt.Fprintln(html, "<h2>Status</h2>")
-	if err := rep.WriteStatusOn(html); err != nil {
-		logHandler.Close()
-		resp.StatusCode = 500
-		resp.Body = logBuffer.String()
-		return resp, err
-	}
-	fmt.Fprintln(html, "<h2>Schedule</h2>")
-	if err := rep.WriteScheduleOn(html); err != nil {
-		logHandler.Close()
-		resp.StatusCode = 500
-		resp.Body = logBuffer.String()
-		return resp, err
-	}
-	if isDebug {
-		logHandler.Close()
-		fmt.Fprintln(html, "<h2>Log</h2>")
-		html.WriteString(logBuffer.String())
-	}
-	timezoneOn(html)
-	versionOn(html)
-	rep.WriteCloseHTMLOn(html)
-	resp.Body = html.String()
-	return resp, nil
+	exceptions, err := mac.ParseExceptionDates(os.Getenv("EXCEPT"))
+	if err != nil {
+		return nil, err
+	}
+	executor.SetExceptions(exceptions)
+	// apply outcome becomes CloudWatch metrics through the function log
+	executor.SetEMFOutput(os.Stdout)
+	notifiers, err := mac.ParseNotifiers(os.Getenv("NOTIFY"), os.Getenv("NOTIFY_TEMPLATE"))
+	if err != nil {
+		return nil, err
+	}
+	for _, each := range notifiers {
+		executor.AddNotifier(each)
+	}
+	executor.SetStatusURL(os.Getenv("STATUS_URL"))
+	policy, err := mac.ParseDeploymentPolicy(os.Getenv("DEPLOYMENTS"), os.Getenv("DEPLOYMENT_GRACE"))
+	if err != nil {
+		return nil, err
+	}
+	executor.SetDeploymentPolicy(policy)
+	if spec := os.Getenv("AUDIT"); spec != "" {
+		store, err := mac.NewAuditStore(spec)
+		if err != nil {
+			return nil, err
+		}
+		executor.SetAudit(store, who())
+	}
+	if state == nil {
+		if state, err = newStateStore(os.Getenv("STATE")); err != nil {
+			return nil, err
+		}
+	}
+	executor.SetStateStore(state)
+	return executor, nil
 }
 
-func wrapLog(logContent string, rep *mac.Reporter) string {
-	html := new(bytes.Buffer)
-	rep.WriteOpenHTMLOn(html)
-	rep.WriteControlsOn(html)
-	fmt.Fprintln(html, "<h2>Log</h2>")
-	html.WriteString(logContent)
-	timezoneOn(html)
-	versionOn(html)
-	rep.WriteCloseHTMLOn(html)
-	return html.String()
+// newStateStore returns the store of the spec or, if empty, a store in memory.
+func newStateStore(spec string) (mac.StateStore, error) {
+	if spec == "" {
+		slog.Warn("no STATE store set, snoozes and deferred stops are kept in memory of this function instance only")
+		return mac.NewMemoryStateStore(), nil
+	}
+	return mac.NewStateStore(spec)
 }
 
-func removeTimeAndLevel(groups []string, a slog.Attr) slog.Attr {
-	if a.Key == "time" || a.Key == "level" {
-		return slog.Attr{}
+func logLevel(r *http.Request) slog.Level {
+	if r.URL.Query().Get("debug") == "true" {
+		return slog.LevelDebug
 	}
-	return a
+	return slog.LevelInfo
 }
 
-func versionOn(w io.Writer) {
-	fmt.Fprintf(w, "<p style='font-size: 10px;'><a href='https://github.com/cloudfork-com/moneypenny-aws-controls'>moneypenny-aws-controls</a> version: %s</p>", Version)
+// auditActor returns the authenticated user, the IAM user of the request or the IAM principal of the function.
+func auditActor(user, callerARN string) string {
+	if user != "" {
+		return user
+	}
+	if callerARN != "" {
+		return callerARN
+	}
+	arn, err := mac.CallerIdentity()
+	if err != nil {
+		slog.Warn("unable to get caller identity", "err", err)
+		return "lambda:" + os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	}
+	return arn
 }
 
-func timezoneOn(w io.Writer) {
-	fmt.Fprintf(w, "<p style='font-size: 10px;'>time-zone: %s</p>", os.Getenv("TIME_ZONE"))
+func removeTimeAndLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == "time" || a.Key == "level" {
+		return slog.Attr{}
+	}
+	return a
 }
@@ -0,0 +1,304 @@
+package mac
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the path prefix of the JSON API.
+const apiPrefix = "/api/v1/"
+
+//go:embed assets/openapi.json
+var OpenAPIDocument []byte
+
+// apiError is the body of each failed JSON API response.
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiEvent is a scheduled state change of a service.
+type apiEvent struct {
+	DesiredState string    `json:"desired-state"`
+	DesiredCount int       `json:"desired-count"`
+	At           time.Time `json:"at"`
+}
+
+// apiService is the actual and the scheduled state of a service with a plan.
+type apiService struct {
+	ServiceARN   string    `json:"service-arn"`
+	Cluster      string    `json:"cluster"`
+	Name         string    `json:"name"`
+	State        string    `json:"state,omitempty"`
+	DesiredCount int       `json:"desired-count"`
+	RunningCount int       `json:"running-count"`
+	PendingCount int       `json:"pending-count"`
+	Scheduled    *apiEvent `json:"scheduled,omitempty"` // last scheduled event
+	Next         *apiEvent `json:"next,omitempty"`      // next scheduled event
+	Disabled     bool      `json:"disabled"`
+	Source       string    `json:"source"`
+	Tag          string    `json:"tag,omitempty"`
+	Stop         string    `json:"stop"`
+	Error        string    `json:"error,omitempty"` // if describing the service failed
+}
+
+// apiScheduleDay has the state changes of all services on one day of the week.
+type apiScheduleDay struct {
+	Weekday string              `json:"weekday"`
+	Changes []apiScheduleChange `json:"changes"`
+}
+
+type apiScheduleChange struct {
+	Time         string `json:"time"` // 08:00
+	ServiceARN   string `json:"service-arn"`
+	Cluster      string `json:"cluster"`
+	Name         string `json:"name"`
+	DesiredState string `json:"desired-state"`
+	DesiredCount int    `json:"desired-count"`
+}
+
+// apiChange is a ServiceChange of a plan or apply.
+type apiChange struct {
+	ServiceARN   string `json:"service-arn"`
+	Cluster      string `json:"cluster"`
+	Name         string `json:"name"`
+	Action       string `json:"action"`
+	TaskCount    int    `json:"task-count"`
+	DesiredCount int    `json:"desired-count"`
+	Applied      bool   `json:"applied"`
+	Stop         string `json:"stop,omitempty"`
+	Note         string `json:"note,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// serveAPI handles the routes of the JSON API:
+//
+//	GET  /api/v1/openapi.json
+//	GET  /api/v1/services
+//	GET  /api/v1/schedule
+//	POST /api/v1/services/{arn}/start|stop|scale
+//	POST /api/v1/plan
+//	POST /api/v1/apply
+func (h *ControlsHandler) serveAPI(w http.ResponseWriter, r *http.Request) {
+	route := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	method := http.MethodPost
+	switch route {
+	case "openapi.json", "services", "schedule":
+		method = http.MethodGet
+	case "plan", "apply":
+	default:
+		if !strings.HasPrefix(route, "services/") {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("no such route: %s", r.URL.Path))
+			return
+		}
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed, use %s", r.Method, method))
+		return
+	}
+	// a cross-site form or simple request cannot send this content type without a CORS preflight
+	if method == http.MethodPost && !isJSONContent(r) {
+		writeAPIError(w, http.StatusUnsupportedMediaType, errors.New("POST requires Content-Type: application/json"))
+		return
+	}
+	if route == "openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(OpenAPIDocument)
+		return
+	}
+	if !strings.HasPrefix(route, "services/") && !authorize(r, route, Service{}) {
+		writeAPIError(w, http.StatusForbidden, errForbidden)
+		return
+	}
+	executor, err := h.newExecutor(r)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	switch route {
+	case "services":
+		list := []apiService{}
+		for _, each := range executor.plans {
+			list = append(list, executor.apiService(each))
+		}
+		writeAPIResult(w, list)
+	case "schedule":
+		writeAPIResult(w, executor.apiSchedule())
+	case "plan":
+		if err := executor.Plan(); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIResult(w, apiChanges(executor.Changes()))
+	case "apply":
+		if err := executor.Apply(); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIResult(w, apiChanges(executor.Changes()))
+	default:
+		h.serveServiceAction(w, r, executor, strings.TrimPrefix(route, "services/"))
+	}
+}
+
+// serveServiceAction handles {arn}/start, {arn}/stop and {arn}/scale; the ARN may be path escaped.
+func (h *ControlsHandler) serveServiceAction(w http.ResponseWriter, r *http.Request, executor *PlanExecutor, route string) {
+	slash := strings.LastIndex(route, "/")
+	if slash == -1 {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("missing action in route: %s", r.URL.Path))
+		return
+	}
+	arn, action := route[:slash], route[slash+1:]
+	if !authorize(r, action, Service{ARN: arn}) {
+		writeAPIError(w, http.StatusForbidden, errForbidden)
+		return
+	}
+	var plan *ServicePlan
+	for _, each := range executor.plans {
+		if each.ARN == arn {
+			plan = each
+		}
+	}
+	if plan == nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no plan for service: %s", arn))
+		return
+	}
+	var err error
+	switch action {
+	case "start":
+		err = executor.Start(arn)
+	case "stop":
+		err = executor.Stop(arn)
+	case "scale":
+		count, cerr := scaleCount(r)
+		if cerr != nil {
+			writeAPIError(w, http.StatusBadRequest, cerr)
+			return
+		}
+		err = executor.ChangeTaskCount(arn, strconv.Itoa(count))
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown action %q, expected start, stop or scale", action))
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAPIResult(w, executor.apiService(plan))
+}
+
+// isJSONContent returns whether the request has the content type application/json, with optional parameters.
+func isJSONContent(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// scaleCount returns the count of the body {"count":2}.
+func scaleCount(r *http.Request) (int, error) {
+	body := struct {
+		Count *int `json:"count"`
+	}{}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return 0, fmt.Errorf("invalid body: %w", err)
+		}
+	}
+	if body.Count == nil {
+		return 0, errors.New("no count was given")
+	}
+	if *body.Count < 0 {
+		return 0, fmt.Errorf("count must not be negative, got %d", *body.Count)
+	}
+	return *body.Count, nil
+}
+
+func (p *PlanExecutor) apiService(plan *ServicePlan) apiService {
+	now := p.clock().In(userLocation)
+	s := apiService{
+		ServiceARN: plan.ARN,
+		Cluster:    plan.ClusterName(),
+		Name:       plan.Name(),
+		Disabled:   plan.Disabled,
+		Source:     plan.SourceLabel(),
+		Tag:        plan.CronLabel(),
+		Stop:       plan.StopStrategy().String(),
+	}
+	if info, err := DescribeServiceInfo(p.client, plan.Service); err != nil {
+		s.Error = err.Error()
+	} else {
+		s.State = info.State()
+		s.DesiredCount, s.RunningCount, s.PendingCount = info.DesiredCount, info.RunningCount, info.PendingCount
+	}
+	if event, ok := p.weekPlan.LastScheduledEventAt(plan.Service, now); ok {
+		s.Scheduled = &apiEvent{DesiredState: event.DesiredState, DesiredCount: event.DesiredCount, At: event.At}
+	}
+	if event, ok := p.weekPlan.NextEventAfter(plan.Service, now); ok {
+		s.Next = &apiEvent{DesiredState: event.DesiredState, DesiredCount: event.DesiredCount, At: event.At}
+	}
+	return s
+}
+
+// apiSchedule returns the state changes of each day of the week, starting on Monday.
+func (p *PlanExecutor) apiSchedule() (list []apiScheduleDay) {
+	for d := 1; d <= 7; d++ {
+		weekday := time.Weekday(d % 7)
+		day := apiScheduleDay{Weekday: weekday.String(), Changes: []apiScheduleChange{}}
+		for _, each := range p.weekPlan.ScheduleForDay(weekday) {
+			day.Changes = append(day.Changes, apiScheduleChange{
+				Time:         fmt.Sprintf("%02d:%02d", each.Hour, each.Minute),
+				ServiceARN:   each.ARN,
+				Cluster:      each.ClusterName(),
+				Name:         each.Name(),
+				DesiredState: each.DesiredState,
+				DesiredCount: each.DesiredCount,
+			})
+		}
+		list = append(list, day)
+	}
+	return
+}
+
+func apiChanges(changes []ServiceChange) []apiChange {
+	list := []apiChange{}
+	for _, each := range changes {
+		c := apiChange{
+			ServiceARN:   each.ARN,
+			Cluster:      each.ClusterName(),
+			Name:         each.Name(),
+			Action:       each.Action,
+			TaskCount:    each.TaskCount,
+			DesiredCount: each.DesiredCount,
+			Applied:      each.Applied,
+			Stop:         each.StopStrategy,
+			Note:         each.Note,
+		}
+		if each.Err != nil {
+			c.Error = each.Err.Error()
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+func writeAPIResult(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{Error: apiError{Status: status, Message: err.Error()}})
+}
@@ -0,0 +1,173 @@
+package mac
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPI(t *testing.T) (*ControlsHandler, *fakeECS) {
+	t.Helper()
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	h := NewControlsHandler("test", func(r *http.Request) (*PlanExecutor, error) {
+		ex := NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5. stop=graceful.")})
+		ex.clock = func() time.Time { return time.Date(2024, 4, 1, 19, 0, 0, 0, userLocation) } // Monday
+		return ex, nil
+	})
+	h.SetStateChangeDelay(0)
+	return h, fake
+}
+
+func serveAPITest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if method == http.MethodPost {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestAPIServices(t *testing.T) {
+	h, _ := newTestAPI(t)
+	rec := serveAPITest(h, http.MethodGet, "/api/v1/services", "")
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	list := []apiService{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(list), 1; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	s := list[0]
+	if got, want := s.State, Running; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := s.Scheduled.DesiredState, Stopped; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := s.Next.At.Weekday(), time.Tuesday; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := s.Stop, "graceful"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestAPISchedule(t *testing.T) {
+	h, _ := newTestAPI(t)
+	rec := serveAPITest(h, http.MethodGet, "/api/v1/schedule", "")
+	days := []apiScheduleDay{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &days); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(days), 7; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := days[0].Weekday, "Monday"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := days[0].Changes[1].Time, "18:00"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(days[6].Changes), 0; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestAPIScaleAndStop(t *testing.T) {
+	h, fake := newTestAPI(t)
+	rec := serveAPITest(h, http.MethodPost, "/api/v1/services/"+testServiceARN+"/scale", `{"count":3}`)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %v want %v: %s", got, want, rec.Body)
+	}
+	serveAPITest(h, http.MethodPost, "/api/v1/services/"+testServiceARN+"/stop", "")
+	if got, want := strings.Join(fake.updates, ","), "api=3,api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestAPIPlan(t *testing.T) {
+	h, fake := newTestAPI(t)
+	rec := serveAPITest(h, http.MethodPost, "/api/v1/plan", "")
+	changes := []apiChange{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := changes[0].Action, ActionStop; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if changes[0].Applied || len(fake.updates) > 0 {
+		t.Error("plan must not apply")
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	h, _ := newTestAPI(t)
+	for _, each := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/api/v1/apply", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/unknown", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/services/arn:aws:ecs:eu-central-1:9111111:service/dev/other/start", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/services/" + testServiceARN + "/restart", "", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/services/" + testServiceARN + "/scale", `{"count":-1}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/services/" + testServiceARN + "/scale", "", http.StatusBadRequest},
+	} {
+		rec := serveAPITest(h, each.method, each.path, each.body)
+		if got, want := rec.Code, each.status; got != want {
+			t.Errorf("%s %s: got %v want %v", each.method, each.path, got, want)
+		}
+		body := struct {
+			Error apiError `json:"error"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Status != each.status || body.Error.Message == "" {
+			t.Errorf("%s %s: unexpected error body %s", each.method, each.path, rec.Body)
+		}
+	}
+}
+
+func TestAPIRequiresJSONContent(t *testing.T) {
+	h, fake := newTestAPI(t)
+	for _, each := range []string{"", "application/x-www-form-urlencoded", "text/plain"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/services/"+testServiceARN+"/scale?count=3", strings.NewReader(`{"count":3}`))
+		if each != "" {
+			r.Header.Set("Content-Type", each)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if got, want := rec.Code, http.StatusUnsupportedMediaType; got != want {
+			t.Errorf("%q: got %v want %v", each, got, want)
+		}
+	}
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	// no fallback to the query
+	if got, want := serveAPITest(h, http.MethodPost, "/api/v1/services/"+testServiceARN+"/scale?count=3", "").Code, http.StatusBadRequest; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestAPIOpenAPIDocument(t *testing.T) {
+	h, _ := newTestAPI(t)
+	rec := serveAPITest(h, http.MethodGet, "/api/v1/openapi.json", "")
+	doc := struct {
+		Paths map[string]any `json:"paths"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{"/api/v1/services", "/api/v1/schedule", "/api/v1/plan", "/api/v1/apply", "/api/v1/services/{arn}/scale"} {
+		if _, ok := doc.Paths[each]; !ok {
+			t.Errorf("missing path %s", each)
+		}
+	}
+}
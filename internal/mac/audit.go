@@ -0,0 +1,162 @@
+package mac
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Sources of an audited action
+const (
+	AuditSourceSchedule = "schedule" // apply of a tag plan
+	AuditSourceOverride = "override" // apply of a local plan
+	AuditSourceManual   = "manual"   // start, stop, change-count or snooze
+	AuditSourceDrift    = "drift"    // correction of a drift
+)
+
+// Actions that are audited, next to the ServiceChange actions
+const (
+	ActionChangeCount = "change-count"
+	ActionSnooze      = "snooze"
+)
+
+// AuditEntry records who did what to which service, and with what outcome.
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	Who           string    `json:"who"` // basic auth user or IAM principal
+	Action        string    `json:"action"`
+	ServiceARN    string    `json:"service-arn"`
+	PreviousCount int       `json:"previous-count"` // desired count before the action, -1 if unknown
+	NewCount      int       `json:"new-count"`
+	Source        string    `json:"source"`
+	Outcome       string    `json:"outcome"`        // ok or the error
+	Note          string    `json:"note,omitempty"` // why the action was taken this way, e.g. deferred for a rollout
+}
+
+// Service returns the service of the entry.
+func (e AuditEntry) Service() Service { return Service{ARN: e.ServiceARN} }
+
+// AuditFilter selects entries; empty fields match all.
+type AuditFilter struct {
+	Service string // name or ARN
+	Action  string
+	Who     string
+	Source  string
+}
+
+func (f AuditFilter) Matches(e AuditEntry) bool {
+	if f.Service != "" && f.Service != e.ServiceARN && f.Service != e.Service().Name() {
+		return false
+	}
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+	if f.Who != "" && f.Who != e.Who {
+		return false
+	}
+	return f.Source == "" || f.Source == e.Source
+}
+
+// AuditStore is an append-only trail of audit entries.
+type AuditStore interface {
+	Append(ctx context.Context, entry AuditEntry) error
+	// Recent returns at most limit matching entries, newest first.
+	Recent(ctx context.Context, filter AuditFilter, limit int) ([]AuditEntry, error)
+}
+
+// Prefixes of the supported audit stores
+const (
+	dynamoDBAuditPrefix   = "dynamodb://"
+	cloudWatchAuditPrefix = "logs://"
+)
+
+// NewAuditStore returns the store for the spec:
+//   - path/to/file.jsonl or file://path/to/file.jsonl
+//   - dynamodb://table
+//   - logs://log-group
+func NewAuditStore(spec string) (AuditStore, error) {
+	switch {
+	case strings.HasPrefix(spec, dynamoDBAuditPrefix), strings.HasPrefix(spec, cloudWatchAuditPrefix):
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := strings.CutPrefix(spec, dynamoDBAuditPrefix); ok {
+			return NewDynamoDBAuditStore(dynamodb.NewFromConfig(cfg), name), nil
+		}
+		return NewCloudWatchAuditStore(cloudwatchlogs.NewFromConfig(cfg), strings.TrimPrefix(spec, cloudWatchAuditPrefix)), nil
+	case spec == "":
+		return nil, errors.New("no audit store given")
+	default:
+		return NewFileAuditStore(strings.TrimPrefix(spec, fileSourcePrefix)), nil
+	}
+}
+
+// CallerIdentity returns the ARN of the IAM principal of the AWS credentials.
+func CallerIdentity() (string, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return "", err
+	}
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Arn), nil
+}
+
+// DesiredCountOfService returns the desired count of the service as known by ECS.
+func DesiredCountOfService(client ECSAPI, service Service) (int, error) {
+	info, err := describeService(client, service)
+	return int(info.DesiredCount), err
+}
+
+// auditOutcome returns ok or the error message.
+func auditOutcome(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// audit appends an entry to the audit store, if any. Failures are logged only.
+func (p *PlanExecutor) audit(action string, service Service, previous, next int, source string, err error, note string) {
+	if p.auditStore == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:          p.clock().UTC(),
+		Who:           p.actor,
+		Action:        action,
+		ServiceARN:    service.ARN,
+		PreviousCount: previous,
+		NewCount:      next,
+		Source:        source,
+		Outcome:       auditOutcome(err),
+		Note:          note,
+	}
+	if err := p.auditStore.Append(context.Background(), entry); err != nil {
+		slog.Error("failed to write audit entry", "err", err, "action", action, "service", service.ARN)
+	}
+}
+
+// previousCount returns the desired count of the service or -1 if unknown; only used for auditing.
+func (p *PlanExecutor) previousCount(service Service) int {
+	if p.auditStore == nil {
+		return -1
+	}
+	n, err := DesiredCountOfService(p.client, service)
+	if err != nil {
+		slog.Warn("unable to get desired count", "service", service.ARN, "err", err)
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package mac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// cloudWatchLogsAPI is the part of the CloudWatch Logs client used for auditing.
+type cloudWatchLogsAPI interface {
+	CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error)
+	PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error)
+	FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error)
+}
+
+const (
+	cloudWatchAuditStream = "moneypenny-audit"
+	cloudWatchAuditPeriod = 30 * 24 * time.Hour // how far Recent looks back
+)
+
+// CloudWatchAuditStore puts entries as JSON log events in a stream of a log group.
+type CloudWatchAuditStore struct {
+	client cloudWatchLogsAPI
+	group  string
+}
+
+func NewCloudWatchAuditStore(client cloudWatchLogsAPI, group string) *CloudWatchAuditStore {
+	return &CloudWatchAuditStore{client: client, group: group}
+}
+
+func (s *CloudWatchAuditStore) Append(ctx context.Context, entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	input := &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(cloudWatchAuditStream),
+		LogEvents:     []types.InputLogEvent{{Message: aws.String(string(data)), Timestamp: aws.Int64(entry.Time.UnixMilli())}},
+	}
+	_, err = s.client.PutLogEvents(ctx, input)
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return err
+	}
+	// first entry
+	if _, err := s.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(cloudWatchAuditStream),
+	}); err != nil {
+		return err
+	}
+	_, err = s.client.PutLogEvents(ctx, input)
+	return err
+}
+
+func (s *CloudWatchAuditStore) Recent(ctx context.Context, filter AuditFilter, limit int) (list []AuditEntry, err error) {
+	var token *string
+	for {
+		out, err := s.client.FilterLogEvents(ctx, &cloudwatchlogs.FilterLogEventsInput{
+			LogGroupName:   aws.String(s.group),
+			LogStreamNames: []string{cloudWatchAuditStream},
+			StartTime:      aws.Int64(time.Now().Add(-cloudWatchAuditPeriod).UnixMilli()),
+			NextToken:      token,
+		})
+		if err != nil {
+			var notFound *types.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				return list, nil
+			}
+			return list, err
+		}
+		for _, each := range out.Events {
+			var entry AuditEntry
+			if err := json.Unmarshal([]byte(aws.StringValue(each.Message)), &entry); err != nil {
+				continue
+			}
+			if filter.Matches(entry) {
+				list = append(list, entry)
+			}
+		}
+		if token = out.NextToken; token == nil {
+			break
+		}
+	}
+	// events are oldest first
+	slices.Reverse(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
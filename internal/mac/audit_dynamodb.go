@@ -0,0 +1,105 @@
+package mac
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// dynamoDBAPI is the part of the DynamoDB client used for auditing.
+type dynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// dynamoDBAuditPartition is the value of the partition key of all entries; the sort key is the time.
+const dynamoDBAuditPartition = "moneypenny-audit"
+
+// DynamoDBAuditStore puts entries in a table with partition key "pk" (S) and sort key "sk" (S).
+type DynamoDBAuditStore struct {
+	client dynamoDBAPI
+	table  string
+}
+
+func NewDynamoDBAuditStore(client dynamoDBAPI, table string) *DynamoDBAuditStore {
+	return &DynamoDBAuditStore{client: client, table: table}
+}
+
+func (s *DynamoDBAuditStore) Append(ctx context.Context, entry AuditEntry) error {
+	item := map[string]types.AttributeValue{
+		"pk":             &types.AttributeValueMemberS{Value: dynamoDBAuditPartition},
+		"sk":             &types.AttributeValueMemberS{Value: entry.Time.UTC().Format(time.RFC3339Nano) + "#" + entry.ServiceARN},
+		"time":           &types.AttributeValueMemberS{Value: entry.Time.UTC().Format(time.RFC3339Nano)},
+		"who":            &types.AttributeValueMemberS{Value: entry.Who},
+		"action":         &types.AttributeValueMemberS{Value: entry.Action},
+		"service-arn":    &types.AttributeValueMemberS{Value: entry.ServiceARN},
+		"previous-count": &types.AttributeValueMemberN{Value: strconv.Itoa(entry.PreviousCount)},
+		"new-count":      &types.AttributeValueMemberN{Value: strconv.Itoa(entry.NewCount)},
+		"source":         &types.AttributeValueMemberS{Value: entry.Source},
+		"outcome":        &types.AttributeValueMemberS{Value: entry.Outcome},
+		"note":           &types.AttributeValueMemberS{Value: entry.Note},
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	return err
+}
+
+func (s *DynamoDBAuditStore) Recent(ctx context.Context, filter AuditFilter, limit int) (list []AuditEntry, err error) {
+	var start map[string]types.AttributeValue
+	for {
+		out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(s.table),
+			KeyConditionExpression:    aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: dynamoDBAuditPartition}},
+			ScanIndexForward:          aws.Bool(false), // newest first
+			ExclusiveStartKey:         start,
+		})
+		if err != nil {
+			return list, err
+		}
+		for _, each := range out.Items {
+			entry := auditEntryFromItem(each)
+			if filter.Matches(entry) {
+				list = append(list, entry)
+				if len(list) == limit {
+					return list, nil
+				}
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return list, nil
+		}
+		start = out.LastEvaluatedKey
+	}
+}
+
+func auditEntryFromItem(item map[string]types.AttributeValue) AuditEntry {
+	str := func(key string) string {
+		if v, ok := item[key].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	num := func(key string) int {
+		if v, ok := item[key].(*types.AttributeValueMemberN); ok {
+			n, _ := strconv.Atoi(v.Value)
+			return n
+		}
+		return 0
+	}
+	when, _ := time.Parse(time.RFC3339Nano, str("time"))
+	return AuditEntry{
+		Time:          when,
+		Who:           str("who"),
+		Action:        str("action"),
+		ServiceARN:    str("service-arn"),
+		PreviousCount: num("previous-count"),
+		NewCount:      num("new-count"),
+		Source:        str("source"),
+		Outcome:       str("outcome"),
+		Note:          str("note"),
+	}
+}
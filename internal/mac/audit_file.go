@@ -0,0 +1,67 @@
+package mac
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+)
+
+// FileAuditStore appends entries as JSON lines to a local file.
+type FileAuditStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileAuditStore(path string) *FileAuditStore {
+	return &FileAuditStore{path: path}
+}
+
+func (s *FileAuditStore) Append(ctx context.Context, entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileAuditStore) Recent(ctx context.Context, filter AuditFilter, limit int) (list []AuditEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return list, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // skip damaged lines
+		}
+		if filter.Matches(entry) {
+			list = append(list, entry)
+		}
+	}
+	slices.Reverse(list)
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, scanner.Err()
+}
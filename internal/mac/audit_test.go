@@ -0,0 +1,170 @@
+package mac
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// localDynamoDB is a stand-in for a DynamoDB table with the keys pk and sk.
+type localDynamoDB struct {
+	mu    sync.Mutex
+	items []map[string]types.AttributeValue
+}
+
+func (l *localDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := l.index(params.Item); i != -1 {
+		l.items[i] = params.Item
+	} else {
+		l.items = append(l.items, params.Item)
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (l *localDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pk := params.ExpressionAttributeValues[":pk"].(*types.AttributeValueMemberS).Value
+	out := &dynamodb.QueryOutput{}
+	for _, each := range l.items {
+		if each["pk"].(*types.AttributeValueMemberS).Value == pk {
+			out.Items = append(out.Items, each)
+		}
+	}
+	sk := func(item map[string]types.AttributeValue) string { return item["sk"].(*types.AttributeValueMemberS).Value }
+	slices.SortFunc(out.Items, func(a, b map[string]types.AttributeValue) int { return strings.Compare(sk(a), sk(b)) })
+	if !aws.BoolValue(params.ScanIndexForward) {
+		slices.Reverse(out.Items)
+	}
+	return out, nil
+}
+
+// localLogs is a stand-in for a CloudWatch log group.
+type localLogs struct {
+	streams map[string][]cwtypes.InputLogEvent
+}
+
+func (l *localLogs) CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	l.streams[aws.StringValue(params.LogStreamName)] = nil
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (l *localLogs) PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	name := aws.StringValue(params.LogStreamName)
+	events, ok := l.streams[name]
+	if !ok {
+		return nil, &cwtypes.ResourceNotFoundException{Message: aws.String("The specified log stream does not exist.")}
+	}
+	l.streams[name] = append(events, params.LogEvents...)
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func (l *localLogs) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	out := &cloudwatchlogs.FilterLogEventsOutput{}
+	for _, name := range params.LogStreamNames {
+		for _, each := range l.streams[name] {
+			out.Events = append(out.Events, cwtypes.FilteredLogEvent{Message: each.Message, Timestamp: each.Timestamp})
+		}
+	}
+	return out, nil
+}
+
+func testAuditStores(t *testing.T) map[string]AuditStore {
+	return map[string]AuditStore{
+		"file":       NewFileAuditStore(filepath.Join(t.TempDir(), "audit.jsonl")),
+		"dynamodb":   NewDynamoDBAuditStore(new(localDynamoDB), "audit"),
+		"cloudwatch": NewCloudWatchAuditStore(&localLogs{streams: map[string][]cwtypes.InputLogEvent{}}, "audit"),
+	}
+}
+
+func TestAuditStores(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	entries := []AuditEntry{
+		{Time: now.Add(-2 * time.Minute), Who: "alice", Action: ActionStart, ServiceARN: testServiceARN, PreviousCount: 0, NewCount: 1, Source: AuditSourceManual, Outcome: "ok"},
+		{Time: now.Add(-time.Minute), Who: "scheduler", Action: ActionStop, ServiceARN: testServiceARN, PreviousCount: 1, NewCount: 0, Source: AuditSourceSchedule, Outcome: "ok"},
+		{Time: now, Who: "alice", Action: ActionChangeCount, ServiceARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/web", PreviousCount: 1, NewCount: 3, Source: AuditSourceManual, Outcome: "denied"},
+	}
+	for name, store := range testAuditStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, each := range entries {
+				if err := store.Append(context.Background(), each); err != nil {
+					t.Fatal(err)
+				}
+			}
+			all, err := store.Recent(context.Background(), AuditFilter{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(all), 3; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := all[0], entries[2]; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+			alice, _ := store.Recent(context.Background(), AuditFilter{Who: "alice"}, 10)
+			if got, want := len(alice), 2; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+			api, _ := store.Recent(context.Background(), AuditFilter{Service: "api", Source: AuditSourceSchedule}, 10)
+			if got, want := len(api), 1; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+			limited, _ := store.Recent(context.Background(), AuditFilter{}, 1)
+			if got, want := len(limited), 1; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExecutorAudit(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 0, nil)
+	plan := newTestPlan(t, testServiceARN, "running=0 0 0-6.")
+	plan.Source = SourceFile
+	ex := NewPlanExecutor(fake, []*ServicePlan{plan})
+	store := NewFileAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	ex.SetAudit(store, "alice")
+	ex.Apply()
+	ex.ChangeTaskCount(testServiceARN, "3")
+	list, err := store.Recent(context.Background(), AuditFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(list), 2; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	manual, apply := list[0], list[1]
+	if apply.Action != ActionStart || apply.Source != AuditSourceOverride || apply.NewCount != 1 || apply.Who != "alice" || apply.Outcome != "ok" {
+		t.Errorf("unexpected apply entry %+v", apply)
+	}
+	if manual.Action != ActionChangeCount || manual.Source != AuditSourceManual || manual.PreviousCount != 1 || manual.NewCount != 3 {
+		t.Errorf("unexpected manual entry %+v", manual)
+	}
+}
+
+func TestAuditWriter(t *testing.T) {
+	store := NewFileAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	store.Append(context.Background(), AuditEntry{Time: time.Now(), Who: "alice", Action: ActionStop, ServiceARN: testServiceARN, Source: AuditSourceManual, Outcome: "ok"})
+	b := new(strings.Builder)
+	if err := (AuditWriter{Filter: AuditFilter{Source: AuditSourceManual}}).WriteOn(store, b); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{"<td>alice</td>", "<td>api</td>", `<option value="manual" selected>`} {
+		if !strings.Contains(b.String(), each) {
+			t.Errorf("missing %q in %s", each, b.String())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package mac
+
+import (
+	"context"
+	_ "embed"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/emicklei/tre"
+)
+
+//go:embed assets/audit.html
+var auditHTML string
+
+// AuditWriter writes the recent audit entries as an HTML table with filters.
+type AuditWriter struct {
+	Filter AuditFilter
+	Limit  int // maximum number of entries, 100 if zero
+}
+
+type auditRow struct {
+	AuditEntry
+	Time string // in the user's timezone
+}
+
+func (r AuditWriter) WriteOn(store AuditStore, w io.Writer) error {
+	tmpl, err := template.New("audit").Parse(auditHTML)
+	if err != nil {
+		return tre.New(err, "parse template fail")
+	}
+	limit := r.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	entries, err := store.Recent(context.Background(), r.Filter, limit)
+	if err != nil {
+		return tre.New(err, "audit read fail")
+	}
+	data := struct {
+		Filter  AuditFilter
+		Sources []string
+		Entries []auditRow
+	}{Filter: r.Filter, Sources: []string{AuditSourceSchedule, AuditSourceOverride, AuditSourceManual}}
+	for _, each := range entries {
+		data.Entries = append(data.Entries, auditRow{AuditEntry: each, Time: each.Time.In(userLocation).Format(time.DateTime)})
+	}
+	return tre.New(tmpl.Execute(w, data), "template exec fail")
+}
@@ -0,0 +1,69 @@
+package mac
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Keys of the context that an API Gateway Lambda authorizer passes to the controls.
+const (
+	AuthorizerUserKey     = "user"
+	AuthorizerRoleKey     = "role"
+	AuthorizerClustersKey = "clusters" // comma separated
+)
+
+// SingleUser returns the users document of one admin user with a bcrypt password hash.
+func SingleUser(name, passwordHash string) (*Users, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing user name")
+	}
+	if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+		return nil, fmt.Errorf("user %s: invalid password-hash: %w", name, err)
+	}
+	return &Users{list: []*User{{Name: name, PasswordHash: passwordHash, Role: RoleAdmin}}}, nil
+}
+
+// AuthenticateBasic returns the user of the basic credentials in the value of an Authorization header.
+func (u *Users) AuthenticateBasic(authorization string) (*User, bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {authorization}}}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	return u.Authenticate(name, password)
+}
+
+// AuthorizerContext returns the context of an authorizer response for the user; values are strings to fit all response formats.
+func AuthorizerContext(user *User) map[string]any {
+	return map[string]any{
+		AuthorizerUserKey:     user.Name,
+		AuthorizerRoleKey:     user.Role,
+		AuthorizerClustersKey: strings.Join(user.Clusters, ","),
+	}
+}
+
+// UserFromAuthorizerContext returns the user that an authorizer has put in the context of a request, if any.
+func UserFromAuthorizerContext(authorizer map[string]any) (*User, bool) {
+	name, _ := authorizer[AuthorizerUserKey].(string)
+	role, _ := authorizer[AuthorizerRoleKey].(string)
+	if name == "" {
+		return nil, false
+	}
+	if _, ok := roleLevels[role]; !ok {
+		return nil, false
+	}
+	user := &User{Name: name, Role: role}
+	if clusters, _ := authorizer[AuthorizerClustersKey].(string); clusters != "" {
+		user.Clusters = strings.Split(clusters, ",")
+	}
+	return user, true
+}
+
+// WithAccount returns a context that carries the authenticated user, for authorization and the audit.
+func WithAccount(ctx context.Context, user *User) context.Context {
+	return context.WithValue(WithUser(ctx, user.Name), accountKey{}, user)
+}
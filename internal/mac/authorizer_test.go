@@ -0,0 +1,73 @@
+package mac
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticateBasic(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	users, err := SingleUser("moneypenny", string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+	user, ok := users.AuthenticateBasic(basic("moneypenny", "secret"))
+	if !ok {
+		t.Fatal("expected valid credentials")
+	}
+	if got, want := user.Role, RoleAdmin; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, each := range []string{basic("moneypenny", "wrong"), basic("bond", "secret"), "", "Bearer abc"} {
+		if _, ok := users.AuthenticateBasic(each); ok {
+			t.Errorf("expected invalid credentials for %q", each)
+		}
+	}
+	if _, err := SingleUser("moneypenny", "secret"); err == nil {
+		t.Error("expected error for plain password")
+	}
+}
+
+func TestAuthorizerContextRoundtrip(t *testing.T) {
+	user := &User{Name: "alice", Role: RoleOperator, Clusters: []string{"dev", "test"}}
+	// the context passes through API Gateway as JSON
+	data, _ := json.Marshal(AuthorizerContext(user))
+	authorizer := map[string]any{}
+	json.Unmarshal(data, &authorizer)
+	got, ok := UserFromAuthorizerContext(authorizer)
+	if !ok {
+		t.Fatal("expected user")
+	}
+	if got.Name != "alice" || got.Role != RoleOperator || len(got.Clusters) != 2 {
+		t.Errorf("got %v want %v", got, user)
+	}
+	if _, ok := UserFromAuthorizerContext(map[string]any{"user": "alice", "role": "root"}); ok {
+		t.Error("expected no user for unknown role")
+	}
+	if _, ok := UserFromAuthorizerContext(nil); ok {
+		t.Error("expected no user without context")
+	}
+}
+
+func TestWithAccountAuthorizes(t *testing.T) {
+	user := &User{Name: "alice", Role: RoleOperator, Clusters: []string{"dev"}}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(WithAccount(r.Context(), user))
+	if got, want := UserFrom(r.Context()), "alice"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if authorize(r, "apply", Service{}) {
+		t.Error("operator must not apply")
+	}
+	if !authorize(r, "stop", Service{ARN: "arn:aws:ecs:eu-central-1:123:service/dev/api"}) {
+		t.Error("operator may stop in own cluster")
+	}
+}
@@ -11,6 +11,17 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 )
 
+// ECSAPI is the part of the ECS client used by moneypenny.
+type ECSAPI interface {
+	ListClusters(ctx context.Context, params *ecs.ListClustersInput, optFns ...func(*ecs.Options)) (*ecs.ListClustersOutput, error)
+	ListServices(ctx context.Context, params *ecs.ListServicesInput, optFns ...func(*ecs.Options)) (*ecs.ListServicesOutput, error)
+	DescribeServices(ctx context.Context, params *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+	ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error)
+	DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error)
+	StopTask(ctx context.Context, params *ecs.StopTaskInput, optFns ...func(*ecs.Options)) (*ecs.StopTaskOutput, error)
+	UpdateService(ctx context.Context, params *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error)
+}
+
 func NewECSClient() (*ecs.Client, error) {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
@@ -20,7 +31,7 @@ func NewECSClient() (*ecs.Client, error) {
 	return ecs.NewFromConfig(cfg), nil
 }
 
-func AllServices(client *ecs.Client) (list []types.Service, err error) {
+func AllServices(client ECSAPI) (list []types.Service, err error) {
 	ctx := context.Background()
 
 	var clusterToken *string
@@ -44,7 +55,7 @@ func AllServices(client *ecs.Client) (list []types.Service, err error) {
 					return list, err1
 				}
 				if len(allServices.ServiceArns) == 0 { // InvalidParameterException: Services cannot be empty
-					return list, nil
+					break // next cluster
 				}
 				slog.Debug("describing services", "cluster", eachCluster, "services.count", len(allServices.ServiceArns))
 				allInfos, err2 := client.DescribeServices(context.TODO(), &ecs.DescribeServicesInput{ // TODO paging
@@ -101,7 +112,7 @@ func TagValue(service types.Service, tagKey string) string {
 	return ""
 }
 
-func TasksForService(client *ecs.Client, clusterARN, shortServiceName string) ([]types.Task, error) {
+func TasksForService(client ECSAPI, clusterARN, shortServiceName string) ([]types.Task, error) {
 	slog.Info("collecting tasks", "name", shortServiceName)
 	ctx := context.Background()
 	taskList, err := client.ListTasks(ctx, &ecs.ListTasksInput{
@@ -122,7 +133,7 @@ func TasksForService(client *ecs.Client, clusterARN, shortServiceName string) ([
 	return allInfos.Tasks, nil
 }
 
-func StartService(client *ecs.Client, service Service, desiredTaskCount int) error {
+func StartService(client ECSAPI, service Service, desiredTaskCount int) error {
 	slog.Info("starting service", "arn", service.ARN)
 	count := int32(desiredTaskCount)
 	if count == 0 { //unspecified
@@ -131,7 +142,7 @@ func StartService(client *ecs.Client, service Service, desiredTaskCount int) err
 	return ChangeTaskCountOfService(client, service, int(count))
 }
 
-func StopTask(client *ecs.Client, task types.Task) error {
+func StopTask(client ECSAPI, task types.Task) error {
 	slog.Info("stopping task", "arn", *task.TaskArn)
 	_, err := client.StopTask(context.Background(), &ecs.StopTaskInput{
 		Task:    task.TaskArn,
@@ -141,7 +152,7 @@ func StopTask(client *ecs.Client, task types.Task) error {
 	return err
 }
 
-func ChangeTaskCountOfService(client *ecs.Client, service Service, desiredTaskCount int) error {
+func ChangeTaskCountOfService(client ECSAPI, service Service, desiredTaskCount int) error {
 	slog.Info("changing tasks count of service", "arn", service.ARN, "count", desiredTaskCount)
 	count := int32(desiredTaskCount)
 	_, err := client.UpdateService(context.Background(), &ecs.UpdateServiceInput{
@@ -152,7 +163,7 @@ func ChangeTaskCountOfService(client *ecs.Client, service Service, desiredTaskCo
 	return err
 }
 
-func StopService(client *ecs.Client, service Service) error {
+func StopService(client ECSAPI, service Service) error {
 	slog.Info("stopping service", "arn", service.ARN)
 	if err := ChangeTaskCountOfService(client, service, 0); err != nil {
 		return err
@@ -167,14 +178,3 @@ func StopService(client *ecs.Client, service Service) error {
 	}
 	return nil
 }
-
-func ServiceStatus(client *ecs.Client, service Service) (int, string) {
-	// at least one task must be running
-	tasks, _ := TasksForService(client, service.ClusterARN(), service.Name())
-	for _, each := range tasks {
-		if each.LastStatus != nil {
-			return len(tasks), *each.LastStatus
-		}
-	}
-	return 0, Unknown
-}
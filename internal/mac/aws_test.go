@@ -0,0 +1,16 @@
+package mac
+
+import "testing"
+
+func TestAllServicesSkipsClusterWithoutServices(t *testing.T) {
+	fake := newFakeECS()
+	fake.clusters = []string{"arn:aws:ecs:eu-central-1:9111111:cluster/acc"}
+	fake.addService("arn:aws:ecs:eu-central-1:9111111:service/dev/api", 1, nil)
+	list, err := AllServices(fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(list), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
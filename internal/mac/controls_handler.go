@@ -0,0 +1,366 @@
+package mac
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emicklei/htmlslog"
+)
+
+// ControlsHandler serves the status page and the actions (?do=...) of the controls UI, and the JSON API.
+// It is shared by the AWS Lambda and the serve command.
+type ControlsHandler struct {
+	newExecutor      func(r *http.Request) (*PlanExecutor, error)
+	version          string
+	stateChangeDelay time.Duration // wait after an action to allow state change
+	statusRefresh    time.Duration // how often the status page updates its rows; zero for never
+	logHandler       slog.Handler  // receives the log of each request too
+	csrfSecret       []byte        // for the tokens of posted actions
+	mutex            sync.Mutex    // one request at a time; the default logger is replaced per request
+}
+
+// NewControlsHandler returns a handler that uses newExecutor to get the executor for each request.
+func NewControlsHandler(version string, newExecutor func(r *http.Request) (*PlanExecutor, error)) *ControlsHandler {
+	return &ControlsHandler{
+		newExecutor:      newExecutor,
+		version:          version,
+		stateChangeDelay: 1 * time.Second,
+		statusRefresh:    10 * time.Second,
+		logHandler:       slog.NewTextHandler(os.Stderr, nil),
+		csrfSecret:       newCSRFSecret(),
+	}
+}
+
+// SetCSRFSecret sets the secret of the tokens that are required to post actions.
+// It must be the same for all instances that serve the pages; by default it is random.
+// A secret shorter than MinCSRFSecretLength is rejected and leaves the secret unchanged.
+func (h *ControlsHandler) SetCSRFSecret(secret string) error {
+	if len(secret) < MinCSRFSecretLength {
+		return fmt.Errorf("csrf secret must have at least %d characters", MinCSRFSecretLength)
+	}
+	h.csrfSecret = []byte(secret)
+	return nil
+}
+
+// SetLogHandler sets the handler that receives the log of each request, next to the page.
+// It must not be the initial default handler of slog which writes to the standard logger.
+func (h *ControlsHandler) SetLogHandler(handler slog.Handler) { h.logHandler = handler }
+
+// SetStateChangeDelay sets how long to wait after start, stop, apply and change-count before rendering the log.
+func (h *ControlsHandler) SetStateChangeDelay(d time.Duration) { h.stateChangeDelay = d }
+
+// SetStatusRefresh sets how often the status page updates its rows in place; zero disables it.
+func (h *ControlsHandler) SetStatusRefresh(d time.Duration) { h.statusRefresh = d }
+
+func (h *ControlsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	defer saveLogContext()()
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		h.serveAPI(w, r)
+		return
+	}
+	// the values of a posted form include those of the query
+	query := r.URL.Query()
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		query = r.Form
+	}
+	// capture the log of this request, passing it through to the log handler
+	isDebug := query.Get("debug") == "true"
+	logLevel := slog.LevelInfo
+	if isDebug {
+		logLevel = slog.LevelDebug
+	}
+	logBuffer := new(bytes.Buffer)
+	logHandler := htmlslog.New(logBuffer, htmlslog.Options{
+		Title:              "moneypenny-aws-controls",
+		TimeLayout:         time.RFC3339,
+		Level:              logLevel,
+		PassthroughHandler: h.logHandler,
+		TableOnly:          true})
+	slog.SetDefault(slog.New(logHandler))
+	action := query.Get("do")
+	if isMutatingAction(query) && action == "drift" {
+		action = "correct-drift"
+	}
+	if !authorize(r, action, Service{ARN: query.Get("service-arn")}) {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	// actions that change services require a POST with a token
+	user := UserFrom(r.Context())
+	if isMutatingAction(query) {
+		if r.Method != http.MethodPost {
+			h.writeConfirmation(w, query, newCSRFToken(h.csrfSecret, user, time.Now()))
+			return
+		}
+		if !validCSRFToken(h.csrfSecret, user, query.Get(csrfFieldName), time.Now()) {
+			slog.Warn("invalid or missing csrf token", "do", query.Get("do"), "user", user)
+			http.Error(w, "invalid or expired form, reload the page and try again", http.StatusForbidden)
+			return
+		}
+	}
+
+	failed := func(err error) {
+		slog.Error("request failed", "do", query.Get("do"), "err", err)
+		logHandler.Close()
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(logBuffer.Bytes())
+	}
+
+	executor, err := h.newExecutor(r)
+	if err != nil {
+		failed(err)
+		return
+	}
+	rep := NewReporter(executor)
+	rep.SetCSRFToken(newCSRFToken(h.csrfSecret, user, time.Now()))
+	filter, err := ParseServiceFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rep.SetFilter(filter)
+	rep.SetRefresh(h.statusRefresh)
+	serviceARN := query.Get("service-arn")
+	switch query.Get("do") {
+	case "apply":
+		executor.Apply()
+		time.Sleep(h.stateChangeDelay)
+	case "start":
+		executor.Start(serviceARN)
+		time.Sleep(h.stateChangeDelay)
+	case "stop":
+		executor.Stop(serviceARN)
+		time.Sleep(h.stateChangeDelay)
+	case "change-count":
+		time.Sleep(h.stateChangeDelay)
+		executor.ChangeTaskCount(serviceARN, query.Get("count"))
+	case "snooze":
+		if err := executor.Snooze(serviceARN, query.Get("minutes")); err != nil {
+			slog.Error("failed to snooze", "err", err)
+		}
+	case "plan":
+		executor.Plan()
+	case "drift":
+		correctOlderThan := time.Duration(0)
+		if v := query.Get("correct-older-than"); v != "" {
+			if correctOlderThan, err = time.ParseDuration(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		drifts, err := executor.Drift(correctOlderThan)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		rep.WriteDriftOn(w, drifts)
+		return
+	case "ics":
+		ics := new(bytes.Buffer)
+		if err := rep.WriteICSOn(ics, query.Get("cluster"), query.Get("service")); err != nil {
+			failed(err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=UTF-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="moneypenny.ics"`)
+		w.Write(ics.Bytes())
+		return
+	case "status-rows":
+		rows := new(bytes.Buffer)
+		if err := rep.WriteStatusRowsOn(rows); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(rows.Bytes())
+		return
+	case "service":
+		plans := rep.plans()
+		if filter.IsEmpty() || len(plans) != 1 {
+			http.Error(w, fmt.Sprintf("%d services match %s, expected one", len(plans), filter.Query().Encode()), http.StatusNotFound)
+			return
+		}
+		html := new(bytes.Buffer)
+		rep.WriteOpenHTMLOn(html)
+		rep.WriteControlsOn(html)
+		if err := rep.WriteServiceOn(html, plans[0], time.Now()); err != nil {
+			failed(err)
+			return
+		}
+		fmt.Fprintln(html, "<h3>Week</h3>")
+		if err := rep.WriteTimelineOn(html, time.Now()); err != nil {
+			failed(err)
+			return
+		}
+		h.writeFooterOn(html)
+		rep.WriteCloseHTMLOn(html)
+		h.writeHTML(w, html)
+		return
+	case "audit":
+		html := new(bytes.Buffer)
+		rep.WriteOpenHTMLOn(html)
+		rep.WriteControlsOn(html)
+		fmt.Fprintln(html, "<h2>Audit</h2>")
+		filter := AuditFilter{
+			Service: query.Get("service"),
+			Action:  query.Get("action"),
+			Who:     query.Get("who"),
+			Source:  query.Get("source"),
+		}
+		if err := rep.WriteAuditOn(html, filter); err != nil {
+			failed(err)
+			return
+		}
+		h.writeFooterOn(html)
+		rep.WriteCloseHTMLOn(html)
+		h.writeHTML(w, html)
+		return
+	default:
+		html := new(bytes.Buffer)
+		if err := h.writeStatusPageOn(html, rep); err != nil {
+			failed(err)
+			return
+		}
+		if isDebug {
+			logHandler.Close()
+			fmt.Fprintln(html, "<h2>Log</h2>")
+			html.Write(logBuffer.Bytes())
+		}
+		h.writeFooterOn(html)
+		rep.WriteCloseHTMLOn(html)
+		h.writeHTML(w, html)
+		return
+	}
+	// actions show their log
+	logHandler.Close()
+	html := new(bytes.Buffer)
+	rep.WriteOpenHTMLOn(html)
+	rep.WriteControlsOn(html)
+	fmt.Fprintln(html, "<h2>Log</h2>")
+	html.Write(logBuffer.Bytes())
+	h.writeFooterOn(html)
+	rep.WriteCloseHTMLOn(html)
+	h.writeHTML(w, html)
+}
+
+//go:embed assets/confirm.html
+var confirmHTML string
+
+var confirmTemplate = template.Must(template.New("confirm").Parse(confirmHTML))
+
+// writeConfirmation writes a page with a form that posts the action of the values with the token.
+func (h *ControlsHandler) writeConfirmation(w http.ResponseWriter, values url.Values, token string) {
+	service := Service{ARN: values.Get("service-arn")}
+	data := struct {
+		Question, Title string
+		Fields          map[string]string
+	}{Title: "Confirm " + values.Get("do"), Fields: map[string]string{csrfFieldName: token}}
+	for _, each := range []string{"do", "service-arn", "count", "minutes", "correct-older-than"} {
+		if v := values.Get(each); v != "" {
+			data.Fields[each] = v
+		}
+	}
+	switch values.Get("do") {
+	case "apply":
+		data.Question = "Apply the schedule to all services now?"
+	case "start":
+		data.Question = fmt.Sprintf("Start service %s/%s?", service.ClusterName(), service.Name())
+	case "stop":
+		data.Question = fmt.Sprintf("Stop service %s/%s?", service.ClusterName(), service.Name())
+	case "change-count":
+		data.Question = fmt.Sprintf("Change the task count of service %s/%s to %s?", service.ClusterName(), service.Name(), values.Get("count"))
+	case "snooze":
+		data.Question = fmt.Sprintf("Snooze the stop of service %s/%s for %s minutes?", service.ClusterName(), service.Name(), values.Get("minutes"))
+	case "drift":
+		data.Question = fmt.Sprintf("Correct drifts older than %s?", values.Get("correct-older-than"))
+	}
+	rep := NewReporter(nil)
+	html := new(bytes.Buffer)
+	rep.WriteOpenHTMLOn(html)
+	rep.WriteControlsOn(html)
+	if err := confirmTemplate.Execute(html, data); err != nil {
+		slog.Error("confirm template exec fail", "err", err)
+	}
+	h.writeFooterOn(html)
+	rep.WriteCloseHTMLOn(html)
+	h.writeHTML(w, html)
+}
+
+// writeStatusPageOn writes all but the footer and closing of the status page.
+func (h *ControlsHandler) writeStatusPageOn(w io.Writer, rep *Reporter) error {
+	rep.WriteOpenHTMLOn(w)
+	if err := rep.WriteControlsOn(w); err != nil {
+		return err
+	}
+	if err := rep.WriteFilterOn(w); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "<h2>Status</h2>")
+	if err := rep.WriteStatusOn(w); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "<h2>Timeline</h2>")
+	if err := rep.WriteTimelineOn(w, time.Now()); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "<h2>Schedule</h2>")
+	return rep.WriteScheduleOn(w)
+}
+
+func (h *ControlsHandler) writeFooterOn(w io.Writer) {
+	fmt.Fprintf(w, "<p style='font-size: 10px;'>time-zone: %s</p>", os.Getenv("TIME_ZONE"))
+	fmt.Fprintf(w, "<p style='font-size: 10px;'><a href='https://github.com/cloudfork-com/moneypenny-aws-controls'>moneypenny-aws-controls</a> version: %s</p>", h.version)
+}
+
+func (h *ControlsHandler) writeHTML(w http.ResponseWriter, html *bytes.Buffer) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Write(html.Bytes())
+}
+
+type userKey struct{}
+
+// WithUser returns a context that carries the authenticated user, for the audit.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the authenticated user of the context, if any.
+func UserFrom(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// BasicAuth returns a handler that requires the user and password and passes the user in the request context.
+func BasicAuth(user, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			slog.Warn("invalid credentials", "user-length", len(u), "pass-length", len(p))
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+	})
+}
@@ -0,0 +1,207 @@
+package mac
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestControlsHandler(t *testing.T) (*ControlsHandler, *fakeECS) {
+	t.Helper()
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	h := NewControlsHandler("test", func(r *http.Request) (*PlanExecutor, error) {
+		return NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5.")}), nil
+	})
+	h.SetStateChangeDelay(0)
+	return h, fake
+}
+
+func TestControlsHandlerDebugHidesEnvironment(t *testing.T) {
+	t.Setenv("MONEYPENNY_TEST_SECRET", "s3cr3t-value")
+	h, _ := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?debug=true", nil))
+	if strings.Contains(rec.Body.String(), "s3cr3t-value") {
+		t.Error("environment must not be shown")
+	}
+}
+
+func TestControlsHandlerStatusPage(t *testing.T) {
+	h, _ := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, each := range []string{"<h2>Status</h2>", "<h2>Schedule</h2>", "api", "version: test"} {
+		if !strings.Contains(rec.Body.String(), each) {
+			t.Errorf("missing %q", each)
+		}
+	}
+}
+
+func TestControlsHandlerStop(t *testing.T) {
+	h, fake := newTestControlsHandler(t)
+	form := url.Values{"do": {"stop"}, "service-arn": {testServiceARN}}
+	// GET only asks for confirmation
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?"+form.Encode(), nil))
+	if !strings.Contains(rec.Body.String(), "Stop service dev/api?") {
+		t.Errorf("missing confirmation in %s", rec.Body)
+	}
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	// POST without token
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newFormRequest(form))
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	// POST with token
+	form.Set(csrfFieldName, newCSRFToken(h.csrfSecret, "", time.Now()))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newFormRequest(form))
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if !strings.Contains(rec.Body.String(), "<h2>Log</h2>") {
+		t.Error("missing log")
+	}
+}
+
+func TestControlsHandlerApplyRequiresToken(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	h := NewControlsHandler("test", func(r *http.Request) (*PlanExecutor, error) {
+		return NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, "stopped=0 0 0-6.")}), nil
+	})
+	h.SetStateChangeDelay(0)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?do=apply", nil))
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	form := url.Values{"do": {"apply"}, csrfFieldName: {newCSRFToken(h.csrfSecret, "", time.Now())}}
+	h.ServeHTTP(httptest.NewRecorder(), newFormRequest(form))
+	if got, want := len(fake.updates), 1; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+}
+
+func TestControlsHandlerStatusPagePostsActions(t *testing.T) {
+	h, _ := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), "?do=stop") {
+		t.Error("unexpected GET link to stop")
+	}
+	if !strings.Contains(rec.Body.String(), `<input type="hidden" name="csrf-token"`) {
+		t.Error("missing csrf token")
+	}
+}
+
+func newFormRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestCSRFToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	token := newCSRFToken(secret, "moneypenny", now)
+	if !validCSRFToken(secret, "moneypenny", token, now) {
+		t.Error("expected valid token")
+	}
+	if validCSRFToken(secret, "bond", token, now) {
+		t.Error("token of other user must be invalid")
+	}
+	if validCSRFToken([]byte("other"), "moneypenny", token, now) {
+		t.Error("token of other secret must be invalid")
+	}
+	if validCSRFToken(secret, "moneypenny", token, now.Add(CSRFTokenTTL+time.Minute)) {
+		t.Error("expired token must be invalid")
+	}
+	if validCSRFToken(secret, "moneypenny", "", now) {
+		t.Error("empty token must be invalid")
+	}
+}
+
+func TestControlsHandlerDriftBadDuration(t *testing.T) {
+	h, _ := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	form := url.Values{"do": {"drift"}, "correct-older-than": {"soon"}, csrfFieldName: {newCSRFToken(h.csrfSecret, "", time.Now())}}
+	h.ServeHTTP(rec, newFormRequest(form))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	var who string
+	h := BasicAuth("moneypenny", "secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who = UserFrom(r.Context())
+	}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("moneypenny", "wrong")
+	h.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	rec = httptest.NewRecorder()
+	req.SetBasicAuth("moneypenny", "secret")
+	h.ServeHTTP(rec, req)
+	if got, want := who, "moneypenny"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestControlsHandlerStatusRows(t *testing.T) {
+	h, fake := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	page := rec.Body.String()
+	for _, each := range []string{`id="status-` + testServiceARN + `"`, "do', 'status-rows'", "updating every 10 seconds"} {
+		if !strings.Contains(page, each) {
+			t.Errorf("missing %q", each)
+		}
+	}
+	// the service was stopped meanwhile
+	fake.find("", testServiceARN).desired = 0
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?do=status-rows&cluster=dev", nil))
+	if got, want := rec.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	result := struct {
+		Rows []statusRow `json:"rows"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(result.Rows), 1; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	row := result.Rows[0]
+	if got, want := row.ID, "status-"+testServiceARN; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, each := range []string{`class="stopped`, "Start service", `name="csrf-token"`} {
+		if !strings.Contains(row.HTML, each) {
+			t.Errorf("missing %q in %s", each, row.HTML)
+		}
+	}
+	// without refresh
+	h.SetStatusRefresh(0)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), "status-rows") {
+		t.Error("unexpected live update script")
+	}
+}
@@ -0,0 +1,65 @@
+package mac
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSRFTokenTTL is how long the token of a page can be used to post an action.
+const CSRFTokenTTL = 12 * time.Hour
+
+// csrfFieldName is the form field with the token.
+const csrfFieldName = "csrf-token"
+
+// MinCSRFSecretLength is the minimum length of a configured secret of the tokens.
+const MinCSRFSecretLength = 16
+
+// newCSRFSecret returns a random secret, valid for the lifetime of the process.
+func newCSRFSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// newCSRFToken returns a token for the user that expires after CSRFTokenTTL: <expires-unix>.<mac>
+func newCSRFToken(secret []byte, user string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(CSRFTokenTTL).Unix(), 10)
+	return expires + "." + csrfMAC(secret, user, expires)
+}
+
+// validCSRFToken returns true if the token was issued with the secret for the user and has not expired.
+func validCSRFToken(secret []byte, user, token string, now time.Time) bool {
+	expires, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.After(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(csrfMAC(secret, user, expires)))
+}
+
+func csrfMAC(secret []byte, user, expires string) string {
+	m := hmac.New(sha256.New, secret)
+	fmt.Fprintf(m, "%s\n%s", user, expires)
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// isMutatingAction returns true if the action (?do=) of the request values changes services.
+func isMutatingAction(values url.Values) bool {
+	switch values.Get("do") {
+	case "apply", "start", "stop", "change-count", "snooze":
+		return true
+	case "drift":
+		return values.Get("correct-older-than") != ""
+	}
+	return false
+}
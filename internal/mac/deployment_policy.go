@@ -0,0 +1,85 @@
+package mac
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Modes of the deployment policy
+const (
+	DeploymentsDefer   = "defer"   // wait for the rollout to finish, up to the grace period
+	DeploymentsProceed = "proceed" // stop anyway, with a warning
+)
+
+// DefaultDeploymentGrace is how long a stop is deferred at most by default.
+const DefaultDeploymentGrace = 30 * time.Minute
+
+// deferredStateKey is the state with the time the stop of the service was first deferred.
+const deferredStateKey = "moneypenny-deferred-since"
+
+// DeploymentPolicy decides what to do with a scheduled stop of a service with a rollout in progress.
+type DeploymentPolicy struct {
+	Mode  string        // DeploymentsDefer or DeploymentsProceed
+	Grace time.Duration // for DeploymentsDefer
+}
+
+// DefaultDeploymentPolicy defers stops for DefaultDeploymentGrace.
+var DefaultDeploymentPolicy = DeploymentPolicy{Mode: DeploymentsDefer, Grace: DefaultDeploymentGrace}
+
+// ParseDeploymentPolicy returns the policy for a mode and a grace duration such as 30m; empty values are defaults.
+func ParseDeploymentPolicy(mode, grace string) (DeploymentPolicy, error) {
+	policy := DefaultDeploymentPolicy
+	switch mode {
+	case "":
+	case DeploymentsDefer, DeploymentsProceed:
+		policy.Mode = mode
+	default:
+		return policy, fmt.Errorf("unknown deployments mode %q, expected defer or proceed", mode)
+	}
+	if grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			return policy, err
+		}
+		policy.Grace = d
+	}
+	return policy, nil
+}
+
+// stopDuringRollout returns ActionDefer if the stop must wait for the rollout, or ActionStop, and a note about the decision.
+func (p *PlanExecutor) stopDuringRollout(info ServiceInfo, now time.Time) (string, string) {
+	clog := slog.With("name", info.Name(), "rollout-state", info.RolloutState, "deployments", info.Deployments)
+	if p.deployments.Mode == DeploymentsProceed {
+		clog.Warn("service is stopped while a deployment is in progress")
+		return ActionStop, "stopped during rollout"
+	}
+	since, _ := p.state.GetTime(info.Service, deferredStateKey)
+	if since.IsZero() {
+		since = now
+		if !p.dryRun {
+			if err := p.state.SetTime(info.Service, deferredStateKey, since); err != nil {
+				clog.Error("failed to remember deferred stop", "err", err)
+			}
+		}
+	}
+	until := since.Add(p.deployments.Grace)
+	if now.Before(until) {
+		clog.Info(">> DEFERRED: stop waits for the deployment in progress", "until", until)
+		return ActionDefer, "rollout in progress, stop deferred until " + until.In(userLocation).Format("15:04")
+	}
+	clog.Warn("service is stopped while a deployment is still in progress after the grace period", "since", since)
+	return ActionStop, "stopped during rollout after grace period of " + p.deployments.Grace.String()
+}
+
+// clearDeferredStop forgets when the stop of the service was first deferred, if it was.
+// It is called whenever the service is no longer waiting for a rollout, so a later deferral gets the full grace period.
+func (p *PlanExecutor) clearDeferredStop(service Service) {
+	since, err := p.state.GetTime(service, deferredStateKey)
+	if err != nil || since.IsZero() {
+		return
+	}
+	if err := p.state.Clear(service, deferredStateKey); err != nil {
+		slog.Error("failed to clear deferred stop", "name", service.Name(), "err", err)
+	}
+}
@@ -0,0 +1,107 @@
+package mac
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseDeploymentPolicy(t *testing.T) {
+	p, err := ParseDeploymentPolicy("", "")
+	if err != nil || p != DefaultDeploymentPolicy {
+		t.Errorf("got %v %v", p, err)
+	}
+	p, _ = ParseDeploymentPolicy(DeploymentsProceed, "10m")
+	if p.Mode != DeploymentsProceed || p.Grace != 10*time.Minute {
+		t.Errorf("got %v", p)
+	}
+	if _, err := ParseDeploymentPolicy("wait", ""); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// rolloutDeployments are those of a service that is rolling out.
+var rolloutDeployments = []types.Deployment{
+	{Status: aws.String("PRIMARY"), RolloutState: types.DeploymentRolloutStateInProgress},
+	{Status: aws.String("ACTIVE"), RolloutState: types.DeploymentRolloutStateCompleted},
+}
+
+func TestExecutorDefersStopDuringRollout(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 1, mondayAt(18, 5))
+	fake.services[testServiceARN].deployments = rolloutDeployments
+	store := NewFileAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	ex.SetAudit(store, "scheduler")
+	ex.Plan()
+	if got, want := ex.Changes()[0].Action, ActionDefer; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	ex.Apply()
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	// grace period passed
+	*now = now.Add(DefaultDeploymentGrace)
+	ex.Apply()
+	change := ex.Changes()[0]
+	if got, want := change.Action, ActionStop; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if !strings.Contains(change.Note, "after grace period") {
+		t.Errorf("unexpected note %q", change.Note)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, deferredStateKey); got != "" {
+		t.Errorf("deferred state not cleared: %v", got)
+	}
+	entries, _ := store.Recent(context.Background(), AuditFilter{}, 10)
+	if len(entries) != 2 || entries[1].Action != ActionDefer || entries[0].Action != ActionStop {
+		t.Errorf("unexpected audit %v", entries)
+	}
+}
+
+func TestExecutorProceedsStopDuringRollout(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours, 1, mondayAt(18, 5))
+	fake.services[testServiceARN].deployments = rolloutDeployments
+	ex.SetDeploymentPolicy(DeploymentPolicy{Mode: DeploymentsProceed})
+	ex.Apply()
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := ex.Changes()[0].Note, "stopped during rollout"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestManualStopClearsDeferredStop(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours, 1, mondayAt(18, 5))
+	fake.services[testServiceARN].deployments = rolloutDeployments
+	ex.Apply()
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, deferredStateKey); got == "" {
+		t.Fatal("expected deferred state")
+	}
+	if err := ex.Stop(testServiceARN); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, deferredStateKey); got != "" {
+		t.Errorf("deferred state not cleared: %v", got)
+	}
+}
+
+func TestScheduleRunningClearsDeferredStop(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 1, mondayAt(18, 5))
+	fake.services[testServiceARN].deployments = rolloutDeployments
+	ex.Apply()
+	// next morning, the schedule wants the service running again
+	*now = now.Add(14 * time.Hour)
+	ex.Apply()
+	if got, want := ex.Changes()[0].Action, ActionNone; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, deferredStateKey); got != "" {
+		t.Errorf("deferred state not cleared: %v", got)
+	}
+}
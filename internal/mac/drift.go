@@ -0,0 +1,241 @@
+package mac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Kinds of drift between the schedule and the actual state of a service
+const (
+	DriftUnexpectedlyRunning = "unexpectedly-running"
+	DriftUnexpectedlyStopped = "unexpectedly-stopped"
+	DriftWrongCount          = "wrong-count"
+	DriftUnknown             = "unknown" // the service could not be described, see Err
+)
+
+// driftSinceStateKey is the state with the time the current drift of the service was first detected.
+const driftSinceStateKey = "moneypenny-drift-since"
+
+// Drift is a difference between the desired count of the schedule and the actual counts of a service.
+type Drift struct {
+	Service        `json:"-"`
+	Kind           string        `json:"kind"`
+	ScheduledCount int           `json:"scheduled-count"` // ScheduledCountAt(now) of the week plan
+	DesiredCount   int           `json:"desired-count"`   // of the ECS service
+	RunningCount   int           `json:"running-count"`   // of the ECS service
+	Since          time.Time     `json:"since"`
+	Age            time.Duration `json:"-"`
+	Corrected      bool          `json:"corrected"`
+	Note           string        `json:"note,omitempty"` // why the correction was skipped or how it was done
+	Err            error         `json:"-"`              // of the detection or correction
+}
+
+func (d Drift) String() string {
+	if d.Kind == DriftUnknown {
+		return fmt.Sprintf("%s (%v)", d.Kind, d.Err)
+	}
+	return fmt.Sprintf("%s for %s (scheduled %d, desired %d, running %d)", d.Kind, d.Age.Round(time.Minute), d.ScheduledCount, d.DesiredCount, d.RunningCount)
+}
+
+// MarshalJSON adds the service, the age in seconds and the error of the detection or correction.
+func (d Drift) MarshalJSON() ([]byte, error) {
+	type fields Drift
+	errText := ""
+	if d.Err != nil {
+		errText = d.Err.Error()
+	}
+	return json.Marshal(struct {
+		Cluster string `json:"cluster"`
+		Name    string `json:"service"`
+		ARN     string `json:"service-arn"`
+		fields
+		AgeSeconds int    `json:"age-seconds"`
+		Error      string `json:"error,omitempty"`
+	}{d.ClusterName(), d.Name(), d.ARN, fields(d), int(d.Age.Seconds()), errText})
+}
+
+// driftKind returns the kind of drift or empty if the counts match the schedule.
+func driftKind(scheduled, desired, running int) string {
+	switch {
+	case scheduled == 0 && (desired > 0 || running > 0):
+		return DriftUnexpectedlyRunning
+	case scheduled > 0 && desired == 0 && running == 0:
+		return DriftUnexpectedlyStopped
+	case scheduled > 0 && desired != scheduled:
+		return DriftWrongCount
+	}
+	return ""
+}
+
+// describeService returns the ECS service with its tags.
+func describeService(client ECSAPI, service Service) (types.Service, error) {
+	out, err := client.DescribeServices(context.Background(), &ecs.DescribeServicesInput{
+		Cluster:  aws.String(service.ClusterARN()),
+		Services: []string{service.ARN},
+		Include:  []types.ServiceField{types.ServiceFieldTags},
+	})
+	if err != nil {
+		return types.Service{}, err
+	}
+	if len(out.Services) == 0 {
+		return types.Service{}, fmt.Errorf("service not found: %s", service.ARN)
+	}
+	return out.Services[0], nil
+}
+
+// Drift detects the drifts and, if correctOlderThan is positive, corrects those that last at least that long.
+// The time a drift is first detected is kept in the state store, to report how long it lasts.
+func (p *PlanExecutor) Drift(correctOlderThan time.Duration) ([]Drift, error) {
+	setLogContext("drift")
+	drifts, err := p.detectDrift(true)
+	if err != nil || correctOlderThan <= 0 {
+		return drifts, err
+	}
+	return p.CorrectDrift(drifts, correctOlderThan), nil
+}
+
+// DetectDrift compares the actual desired and running counts of the services with their schedule.
+// It does not change any state; a drift that was not seen by Drift before starts now.
+func (p *PlanExecutor) DetectDrift() ([]Drift, error) {
+	return p.detectDrift(false)
+}
+
+// detectDrift returns the drifts and, if remember is true, keeps or clears the time each drift was first detected.
+func (p *PlanExecutor) detectDrift(remember bool) (list []Drift, err error) {
+	now := p.clock().In(userLocation)
+	for _, each := range p.plans {
+		if each.Disabled || len(each.StateChanges) == 0 {
+			continue
+		}
+		clog := slog.With("name", each.Name())
+		// one service that cannot be described, e.g. deleted with its plan left behind, must not stop the others
+		info, err := DescribeServiceInfo(p.client, each.Service)
+		if err != nil {
+			clog.Error("failed to describe service", "err", err)
+			list = append(list, Drift{Service: each.Service, Kind: DriftUnknown, Err: err})
+			continue
+		}
+		since, _ := p.state.GetTime(each.Service, driftSinceStateKey)
+		drift := newDrift(p.weekPlan, each, info, since, now)
+		if drift.Kind == "" {
+			if remember && !since.IsZero() {
+				clog.Info("drift ended", "since", since)
+				if err := p.state.Clear(each.Service, driftSinceStateKey); err != nil {
+					clog.Error("failed to clear drift state", "err", err)
+				}
+			}
+			continue
+		}
+		if remember {
+			if since.IsZero() {
+				if err := p.state.SetTime(each.Service, driftSinceStateKey, drift.Since); err != nil {
+					clog.Error("failed to remember drift", "err", err)
+				}
+			}
+			clog.Warn("drift detected", "kind", drift.Kind, "scheduled", drift.ScheduledCount, "desired", drift.DesiredCount, "running", drift.RunningCount, "since", drift.Since)
+		}
+		list = append(list, drift)
+	}
+	return list, nil
+}
+
+// newDrift returns the drift of the described service of the plan; the Kind is empty if the service is as scheduled.
+// The drift lasts from since, the remembered time it was first detected, or else from now.
+func newDrift(wp *WeekPlan, plan *ServicePlan, info ServiceInfo, since, now time.Time) Drift {
+	drift := Drift{
+		Service:        plan.Service,
+		ScheduledCount: wp.ScheduledCountAt(plan, now),
+		DesiredCount:   info.DesiredCount,
+		RunningCount:   info.RunningCount,
+	}
+	if plan.Disabled || len(plan.StateChanges) == 0 {
+		return drift
+	}
+	drift.Kind = driftKind(drift.ScheduledCount, drift.DesiredCount, drift.RunningCount)
+	if drift.Kind == "" {
+		return drift
+	}
+	if since.IsZero() {
+		since = now
+	}
+	drift.Since, drift.Age = since, now.Sub(since)
+	return drift
+}
+
+// CorrectDrift sets the scheduled count of each service with a drift of at least minAge.
+// A stop uses the stop strategy of the plan and, as with apply, is skipped while snoozed or deferred during a rollout.
+func (p *PlanExecutor) CorrectDrift(drifts []Drift, minAge time.Duration) []Drift {
+	p.dryRun = false
+	now := p.clock().In(userLocation)
+	for i, each := range drifts {
+		if each.Kind == DriftUnknown || each.Age < minAge {
+			continue
+		}
+		clog := slog.With("name", each.Name(), "kind", each.Kind)
+		var err error
+		action, note := ActionRescale, ""
+		if each.ScheduledCount == 0 {
+			action, note, err = p.correctDriftByStop(each, now)
+			if action != ActionStop {
+				drifts[i].Note = note
+				if action == ActionDefer {
+					p.audit(action, each.Service, each.DesiredCount, each.DesiredCount, AuditSourceDrift, nil, note)
+				}
+				continue
+			}
+		} else {
+			clog.Info(">> CHANGE: correcting drift", "count", each.ScheduledCount, "age", each.Age)
+			if each.DesiredCount == 0 {
+				action = ActionStart
+			}
+			if err = ChangeTaskCountOfService(p.client, each.Service, each.ScheduledCount); err == nil {
+				p.clearDrainStart(each.Service)
+			}
+		}
+		if err == nil {
+			p.clearDeferredStop(each.Service)
+		}
+		p.audit(action, each.Service, each.DesiredCount, each.ScheduledCount, AuditSourceDrift, err, note)
+		if err != nil {
+			clog.Error("failed to correct drift", "err", err)
+		}
+		drifts[i].Corrected, drifts[i].Err, drifts[i].Note = err == nil, err, note
+	}
+	return drifts
+}
+
+// correctDriftByStop stops the running service unless it is snoozed (ActionNone) or its stop is deferred (ActionDefer).
+func (p *PlanExecutor) correctDriftByStop(drift Drift, now time.Time) (action, note string, err error) {
+	clog := slog.With("name", drift.Name(), "kind", drift.Kind)
+	until, err := p.snoozedUntil(drift.Service, ScheduledEvent{Service: drift.Service, DesiredState: Stopped}, true, now)
+	if err != nil {
+		return ActionNone, "", err
+	}
+	if !until.IsZero() {
+		clog.Info("drift is not corrected, service is snoozed", "until", until)
+		return ActionNone, "snoozed until " + until.In(userLocation).Format("15:04"), nil
+	}
+	info, err := DescribeServiceInfo(p.client, drift.Service)
+	if err != nil {
+		return ActionStop, "", err
+	}
+	if info.IsRollingOut() {
+		action, note = p.stopDuringRollout(info, now)
+		if action == ActionDefer {
+			return action, note, nil
+		}
+	}
+	strategy := p.stopStrategyOf(drift.Service)
+	clog.Info(">> CHANGE: correcting drift", "count", 0, "age", drift.Age, "stop", strategy)
+	if note == "" {
+		note = "using " + strategy.String()
+	}
+	return ActionStop, note, p.stopService(drift.Service, strategy)
+}
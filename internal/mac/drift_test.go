@@ -0,0 +1,207 @@
+package mac
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDriftKind(t *testing.T) {
+	for _, each := range []struct {
+		scheduled, desired, running int
+		want                        string
+	}{
+		{0, 0, 0, ""},
+		{0, 1, 1, DriftUnexpectedlyRunning},
+		{0, 0, 1, DriftUnexpectedlyRunning},
+		{1, 0, 0, DriftUnexpectedlyStopped},
+		{2, 1, 1, DriftWrongCount},
+		{2, 2, 1, ""}, // tasks still starting
+	} {
+		if got := driftKind(each.scheduled, each.desired, each.running); got != each.want {
+			t.Errorf("%v: got %v want %v", each, got, each.want)
+		}
+	}
+}
+
+func TestDetectDriftSince(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	drifts, err := ex.Drift(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != DriftUnexpectedlyStopped {
+		t.Fatalf("unexpected drifts %v", drifts)
+	}
+	*now = now.Add(time.Hour)
+	drifts, _ = ex.DetectDrift()
+	if got, want := drifts[0].Age, time.Hour; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	// fixed outside moneypenny
+	fake.services[testServiceARN].desired = 1
+	fake.services[testServiceARN].running = 1
+	drifts, _ = ex.Drift(0)
+	if got, want := len(drifts), 0; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, driftSinceStateKey); got != "" {
+		t.Errorf("drift state not cleared: %v", got)
+	}
+}
+
+func TestDetectDriftReadOnly(t *testing.T) {
+	ex, _, now := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	if _, err := ex.DetectDrift(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, driftSinceStateKey); got != "" {
+		t.Errorf("got %v want no drift state", got)
+	}
+	*now = now.Add(time.Hour)
+	drifts, _ := ex.DetectDrift()
+	if got, want := drifts[0].Age, time.Duration(0); got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestCorrectDriftOlderThan(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	ex.Drift(30 * time.Minute)
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	*now = now.Add(45 * time.Minute)
+	drifts, err := ex.Drift(30 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drifts[0].Corrected {
+		t.Error("expected corrected drift")
+	}
+	if got, want := strings.Join(fake.updates, ","), "api=1"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestDriftUnexpectedlyRunningJSON(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	fake.services[testServiceARN].desired = 2
+	fake.services[testServiceARN].running = 2
+	*now = now.Add(8 * time.Hour) // 20:00
+	drifts, _ := ex.DetectDrift()
+	data, err := json.Marshal(drifts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{`"cluster":"dev"`, `"service":"api"`, `"kind":"unexpectedly-running"`, `"scheduled-count":0`, `"desired-count":2`, `"age-seconds":0`} {
+		if !strings.Contains(string(data), each) {
+			t.Errorf("missing %s in %s", each, data)
+		}
+	}
+}
+
+func TestStatusWriterDrift(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	w := StatusWriter{client: ex.client, state: ex.state, clock: ex.clock}
+	b := new(strings.Builder)
+	if err := w.WriteOn(ex.plans, b); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{`class="stopped drift"`, "unexpectedly-stopped for 0s"} {
+		if !strings.Contains(b.String(), each) {
+			t.Errorf("missing %q in %s", each, b.String())
+		}
+	}
+	if got, want := fake.describes, len(ex.plans); got != want {
+		t.Errorf("got %v describes want %v", got, want)
+	}
+}
+
+func TestCorrectDriftUsesStopStrategy(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours+" stop=graceful.", 2, mondayAt(18, 5))
+	drifts, _ := ex.DetectDrift()
+	drifts = ex.CorrectDrift(drifts, 0)
+	if !drifts[0].Corrected {
+		t.Errorf("expected corrected drift %v", drifts[0])
+	}
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(fake.stopped), 0; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+}
+
+func TestCorrectDriftSkipsSnoozedStop(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours+" stop=graceful.", 2, mondayAt(18, 5))
+	if err := ex.Snooze(testServiceARN, "30"); err != nil {
+		t.Fatal(err)
+	}
+	drifts, _ := ex.DetectDrift()
+	drifts = ex.CorrectDrift(drifts, 0)
+	if drifts[0].Corrected {
+		t.Error("expected uncorrected drift")
+	}
+	if !strings.HasPrefix(drifts[0].Note, "snoozed until") {
+		t.Errorf("unexpected note %q", drifts[0].Note)
+	}
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+}
+
+func TestCorrectDriftDefersStopDuringRollout(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours, 1, mondayAt(18, 5))
+	fake.services[testServiceARN].deployments = rolloutDeployments
+	drifts, _ := ex.DetectDrift()
+	drifts = ex.CorrectDrift(drifts, 0)
+	if drifts[0].Corrected {
+		t.Error("expected uncorrected drift")
+	}
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, deferredStateKey); got == "" {
+		t.Error("expected deferred state")
+	}
+}
+
+func TestDriftContinuesAfterDescribeError(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	gone := "arn:aws:ecs:eu-central-1:9111111:service/dev/gone"
+	ex.plans = append([]*ServicePlan{newTestPlan(t, gone, officeHours)}, ex.plans...)
+	drifts, err := ex.DetectDrift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	drifts = ex.CorrectDrift(drifts, 0)
+	if got, want := len(drifts), 2; got != want {
+		t.Fatalf("got %v drifts want %v", got, want)
+	}
+	if drifts[0].Kind != DriftUnknown || drifts[0].Err == nil || drifts[0].Corrected {
+		t.Errorf("unexpected drift %v", drifts[0])
+	}
+	if !drifts[1].Corrected {
+		t.Errorf("expected corrected drift %v", drifts[1])
+	}
+	if got, want := strings.Join(fake.updates, ","), "api=1"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestDetectDriftOnException(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours, 0, mondayAt(12, 0))
+	ex.SetExceptions([]time.Time{*now})
+	drifts, _ := ex.DetectDrift()
+	if got, want := len(drifts), 0; got != want {
+		t.Errorf("got %v drifts want %v", got, want)
+	}
+	fake.services[testServiceARN].desired = 1
+	fake.services[testServiceARN].running = 1
+	drifts, _ = ex.DetectDrift()
+	if len(drifts) != 1 || drifts[0].Kind != DriftUnexpectedlyRunning {
+		t.Errorf("unexpected drifts %v", drifts)
+	}
+}
@@ -0,0 +1,182 @@
+package mac
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// fakeECS is a local stand-in for the ECS API with services that change state immediately.
+type fakeECS struct {
+	mu        sync.Mutex
+	services  map[string]*fakeService // by service ARN
+	clusters  []string                // cluster ARNs without services
+	updates   []string                // name=count of each UpdateService
+	stopped   []string                // task ARNs of each StopTask
+	updateErr error                   // if set, returned by UpdateService
+	describes int                     // number of DescribeServices calls
+}
+
+type fakeService struct {
+	arn                       string
+	desired, running, pending int
+	deployments               []types.Deployment // if nil then one completed PRIMARY deployment
+	tags                      map[string]string
+	draining                  bool // if true then scaling down leaves the tasks running
+}
+
+// officeHours is the tag of a service that runs on weekdays from 8:00 to 18:00.
+const officeHours = "running=0 8 1-5. stopped=0 18 1-5."
+
+// newExecutorTest returns an executor at the time with the plan of the tag for one fake service with count tasks.
+// Tests change the time through the returned pointer.
+func newExecutorTest(t *testing.T, tag string, count int, at time.Time) (*PlanExecutor, *fakeECS, *time.Time) {
+	t.Helper()
+	fake := newFakeECS()
+	fake.addService(testServiceARN, count, nil)
+	ex := NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, tag)})
+	now := at
+	ex.clock = func() time.Time { return now }
+	return ex, fake, &now
+}
+
+// mondayAt returns the time on Monday 2024-04-01 in the user time zone.
+func mondayAt(hour, minute int) time.Time {
+	return time.Date(2024, 4, 1, hour, minute, 0, 0, userLocation)
+}
+
+func newFakeECS() *fakeECS {
+	return &fakeECS{services: map[string]*fakeService{}}
+}
+
+func (f *fakeECS) addService(arn string, running int, tags map[string]string) *fakeService {
+	s := &fakeService{arn: arn, desired: running, running: running, tags: tags}
+	f.services[arn] = s
+	return s
+}
+
+func (f *fakeECS) find(cluster, nameOrARN string) *fakeService {
+	if s, ok := f.services[nameOrARN]; ok {
+		return s
+	}
+	for _, each := range f.services {
+		svc := Service{ARN: each.arn}
+		if svc.Name() == nameOrARN && (cluster == "" || svc.ClusterARN() == cluster) {
+			return each
+		}
+	}
+	return nil
+}
+
+func (f *fakeECS) ListClusters(ctx context.Context, params *ecs.ListClustersInput, optFns ...func(*ecs.Options)) (*ecs.ListClustersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.ListClustersOutput{ClusterArns: slices.Clone(f.clusters)}
+	for _, each := range f.services {
+		c := Service{ARN: each.arn}.ClusterARN()
+		if !slices.Contains(out.ClusterArns, c) {
+			out.ClusterArns = append(out.ClusterArns, c)
+		}
+	}
+	slices.Sort(out.ClusterArns)
+	return out, nil
+}
+
+func (f *fakeECS) ListServices(ctx context.Context, params *ecs.ListServicesInput, optFns ...func(*ecs.Options)) (*ecs.ListServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.ListServicesOutput{}
+	for _, each := range f.services {
+		if (Service{ARN: each.arn}).ClusterARN() == aws.StringValue(params.Cluster) {
+			out.ServiceArns = append(out.ServiceArns, each.arn)
+		}
+	}
+	slices.Sort(out.ServiceArns)
+	return out, nil
+}
+
+func (f *fakeECS) DescribeServices(ctx context.Context, params *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.describes++
+	out := &ecs.DescribeServicesOutput{}
+	for _, each := range params.Services {
+		s := f.find(aws.StringValue(params.Cluster), each)
+		if s == nil {
+			out.Failures = append(out.Failures, types.Failure{Arn: aws.String(each), Reason: aws.String("MISSING")})
+			continue
+		}
+		svc := Service{ARN: s.arn}
+		info := types.Service{
+			ServiceArn:   aws.String(s.arn),
+			ServiceName:  aws.String(svc.Name()),
+			ClusterArn:   aws.String(svc.ClusterARN()),
+			DesiredCount: int32(s.desired),
+			RunningCount: int32(s.running),
+			PendingCount: int32(s.pending),
+			Deployments:  s.deployments,
+		}
+		if info.Deployments == nil {
+			info.Deployments = []types.Deployment{{Status: aws.String("PRIMARY"), RolloutState: types.DeploymentRolloutStateCompleted}}
+		}
+		for k, v := range s.tags {
+			info.Tags = append(info.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		out.Services = append(out.Services, info)
+	}
+	return out, nil
+}
+
+func (f *fakeECS) ListTasks(ctx context.Context, params *ecs.ListTasksInput, optFns ...func(*ecs.Options)) (*ecs.ListTasksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &ecs.ListTasksOutput{}
+	s := f.find(aws.StringValue(params.Cluster), aws.StringValue(params.ServiceName))
+	if s == nil {
+		return out, nil
+	}
+	for i := 0; i < s.running; i++ {
+		out.TaskArns = append(out.TaskArns, fmt.Sprintf("%s/task-%d", s.arn, i))
+	}
+	return out, nil
+}
+
+func (f *fakeECS) DescribeTasks(ctx context.Context, params *ecs.DescribeTasksInput, optFns ...func(*ecs.Options)) (*ecs.DescribeTasksOutput, error) {
+	out := &ecs.DescribeTasksOutput{}
+	for _, each := range params.Tasks {
+		out.Tasks = append(out.Tasks, types.Task{TaskArn: aws.String(each), ClusterArn: params.Cluster, LastStatus: aws.String(Running)})
+	}
+	return out, nil
+}
+
+func (f *fakeECS) StopTask(ctx context.Context, params *ecs.StopTaskInput, optFns ...func(*ecs.Options)) (*ecs.StopTaskOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, aws.StringValue(params.Task))
+	return &ecs.StopTaskOutput{}, nil
+}
+
+func (f *fakeECS) UpdateService(ctx context.Context, params *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	s := f.find(aws.StringValue(params.Cluster), aws.StringValue(params.Service))
+	if s == nil {
+		return nil, fmt.Errorf("service not found: %s", aws.StringValue(params.Service))
+	}
+	s.desired = int(aws.Int32Value(params.DesiredCount))
+	if !s.draining || s.desired > s.running {
+		s.running = s.desired
+	}
+	f.updates = append(f.updates, fmt.Sprintf("%s=%d", Service{ARN: s.arn}.Name(), s.desired))
+	return &ecs.UpdateServiceOutput{}, nil
+}
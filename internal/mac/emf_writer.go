@@ -0,0 +1,80 @@
+package mac
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+)
+
+// EMFNamespace is the CloudWatch namespace of the metrics in the Embedded Metric Format logs.
+const EMFNamespace = "moneypenny-aws-controls"
+
+// EMFWriter writes the outcome of an apply as CloudWatch Embedded Metric Format log lines, one per cluster.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+type EMFWriter struct {
+	Now time.Time // timestamp of the metrics, if zero then time.Now()
+}
+
+type emfCounts struct {
+	started, stopped, rescaled, failed int
+}
+
+func (r EMFWriter) WriteOn(changes []ServiceChange, w io.Writer) error {
+	now := r.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	clusters := []string{}
+	counts := map[string]*emfCounts{}
+	for _, each := range changes {
+		cluster := each.ClusterName()
+		c, ok := counts[cluster]
+		if !ok {
+			c = new(emfCounts)
+			counts[cluster] = c
+			clusters = append(clusters, cluster)
+		}
+		if each.Err != nil {
+			c.failed++
+			continue
+		}
+		switch each.Action {
+		case ActionStart:
+			c.started++
+		case ActionStop:
+			c.stopped++
+		case ActionRescale:
+			c.rescaled++
+		}
+	}
+	slices.Sort(clusters)
+	enc := json.NewEncoder(w)
+	for _, cluster := range clusters {
+		c := counts[cluster]
+		line := map[string]any{
+			"_aws": map[string]any{
+				"Timestamp": now.UnixMilli(),
+				"CloudWatchMetrics": []any{map[string]any{
+					"Namespace":  EMFNamespace,
+					"Dimensions": [][]string{{"Cluster"}},
+					"Metrics": []any{
+						map[string]string{"Name": "Started", "Unit": "Count"},
+						map[string]string{"Name": "Stopped", "Unit": "Count"},
+						map[string]string{"Name": "Rescaled", "Unit": "Count"},
+						map[string]string{"Name": "Failed", "Unit": "Count"},
+					},
+				}},
+			},
+			"Cluster":  cluster,
+			"Started":  c.started,
+			"Stopped":  c.stopped,
+			"Rescaled": c.rescaled,
+			"Failed":   c.failed,
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
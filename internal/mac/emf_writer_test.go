@@ -0,0 +1,85 @@
+package mac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEMFWriter(t *testing.T) {
+	dev := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}
+	prd := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/prd/api"}
+	changes := []ServiceChange{
+		{Service: prd, Action: ActionRescale},
+		{Service: dev, Action: ActionStart},
+		{Service: dev, Action: ActionStop},
+		{Service: dev, Action: ActionStop, Err: errors.New("denied")},
+		{Service: dev, Action: ActionNone},
+	}
+	buf := new(bytes.Buffer)
+	w := EMFWriter{Now: time.UnixMilli(1700000000000)}
+	if err := w.WriteOn(changes, buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("got %v lines want %v", got, want)
+	}
+	var dim struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+			}
+		} `json:"_aws"`
+		Cluster                            string
+		Started, Stopped, Rescaled, Failed int
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &dim); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dim.Cluster, "dev"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if dim.Started != 1 || dim.Stopped != 1 || dim.Rescaled != 0 || dim.Failed != 1 {
+		t.Errorf("unexpected counts %+v", dim)
+	}
+	if got, want := dim.AWS.Timestamp, int64(1700000000000); got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := dim.AWS.CloudWatchMetrics[0].Namespace, EMFNamespace; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := dim.AWS.CloudWatchMetrics[0].Dimensions[0][0], "Cluster"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if !strings.Contains(lines[1], `"Cluster":"prd"`) || !strings.Contains(lines[1], `"Rescaled":1`) {
+		t.Errorf("unexpected line %s", lines[1])
+	}
+}
+
+func TestExecutorApplyWritesEMF(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService("arn:aws:ecs:eu-central-1:9111111:service/dev/api", 0, nil)
+	ex := NewPlanExecutor(fake, []*ServicePlan{
+		newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/api", "running=0 0 0-6."),
+	})
+	buf := new(bytes.Buffer)
+	ex.SetEMFOutput(buf)
+	if err := ex.Plan(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("plan must not write metrics: %s", buf.String())
+	}
+	if err := ex.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Started":1`) {
+		t.Errorf("missing started count in %s", buf.String())
+	}
+}
@@ -0,0 +1,235 @@
+package mac
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ICSWriter writes the running windows of the week plan as an iCalendar with weekly recurring events.
+// The events that overlap an exception date of the week plan are excluded.
+type ICSWriter struct {
+	Cluster string    // if not empty, only services of this cluster (name)
+	Service string    // if not empty, only services with this name
+	Now     time.Time // events start in the week of Now
+}
+
+const icsTimeLayout = "20060102T150405"
+
+func (r ICSWriter) WriteOn(wp *WeekPlan, w io.Writer) error {
+	now := r.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.In(userLocation)
+	tzid := icsTimezoneID(userLocation)
+	weekStart := time.Date(now.Year(), now.Month(), now.Day()-int(now.Weekday()), 0, 0, 0, 0, userLocation)
+
+	out := &icsLineWriter{w: bufio.NewWriter(w)}
+	out.line("BEGIN:VCALENDAR")
+	out.line("VERSION:2.0")
+	out.line("PRODID:-//cloudfork-com//moneypenny-aws-controls//EN")
+	out.line("CALSCALE:GREGORIAN")
+	out.line("X-WR-CALNAME:" + icsText("moneypenny "+r.label()))
+	if tzid != "" {
+		out.line("X-WR-TIMEZONE:" + tzid)
+		writeVTimezoneOn(out, userLocation, tzid, now.Year())
+	}
+	for _, each := range wp.Services() {
+		if r.Cluster != "" && each.ClusterName() != r.Cluster {
+			continue
+		}
+		if r.Service != "" && each.Name() != r.Service {
+			continue
+		}
+		for _, win := range joinWeekWrap(wp.RunningWindows(each)) {
+			start := atMinuteOfWeek(weekStart, win.Start)
+			end := atMinuteOfWeek(weekStart, win.End)
+			out.line("BEGIN:VEVENT")
+			out.line(fmt.Sprintf("UID:%s/%d@moneypenny-aws-controls", each.ARN, win.Start))
+			out.line("DTSTAMP:" + now.UTC().Format(icsTimeLayout) + "Z")
+			out.line("DTSTART" + icsTime(start, tzid))
+			out.line("DTEND" + icsTime(end, tzid))
+			out.line("RRULE:FREQ=WEEKLY")
+			for _, exAt := range excludedOccurrences(win, start, wp.Exceptions) {
+				out.line("EXDATE" + icsTime(exAt, tzid))
+			}
+			out.line("SUMMARY:" + icsText(fmt.Sprintf("%s running (%d)", each.Name(), win.DesiredCount)))
+			out.line("DESCRIPTION:" + icsText(fmt.Sprintf("service %s of cluster %s is running with %d task(s)", each.Name(), each.ClusterName(), win.DesiredCount)))
+			out.line("TRANSP:TRANSPARENT")
+			out.line("END:VEVENT")
+		}
+	}
+	out.line("END:VCALENDAR")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+func (r ICSWriter) label() string {
+	switch {
+	case r.Cluster != "" && r.Service != "":
+		return r.Cluster + "/" + r.Service
+	case r.Cluster != "":
+		return r.Cluster
+	case r.Service != "":
+		return r.Service
+	}
+	return "schedule"
+}
+
+// joinWeekWrap joins a window ending at the end of the week with the one starting at the start of the week.
+func joinWeekWrap(list []RunningWindow) []RunningWindow {
+	if len(list) < 2 {
+		return list
+	}
+	first, last := list[0], list[len(list)-1]
+	if first.Start != 0 || last.End != minutesPerWeek || first.DesiredCount != last.DesiredCount {
+		return list
+	}
+	last.End = minutesPerWeek + first.End
+	return append(list[1:len(list)-1], last)
+}
+
+// excludedOccurrences returns the starts of the weekly occurrences of the window that overlap an exception date,
+// e.g. the occurrence of an overnight window that starts the day before.
+func excludedOccurrences(win RunningWindow, start time.Time, exceptions []time.Time) (list []time.Time) {
+	days := win.End/minutesPerDay - win.Start/minutesPerDay
+	for _, each := range exceptions {
+		dayStart := time.Date(each.Year(), each.Month(), each.Day(), 0, 0, 0, 0, start.Location())
+		dayEnd := dayStart.AddDate(0, 0, 1)
+		// the latest occurrence that starts on or before the exception date, and the one before if the window is long
+		back := (int(dayStart.Weekday()) - int(start.Weekday()) + 7) % 7
+		for _, offset := range []int{back, back + 7} {
+			at := time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day()-offset, start.Hour(), start.Minute(), 0, 0, start.Location())
+			end := time.Date(at.Year(), at.Month(), at.Day()+days, (win.End%minutesPerDay)/60, win.End%60, 0, 0, start.Location())
+			if at.Before(start) || !at.Before(dayEnd) || !end.After(dayStart) || slices.ContainsFunc(list, at.Equal) {
+				continue
+			}
+			list = append(list, at)
+		}
+	}
+	slices.SortFunc(list, func(a, b time.Time) int { return a.Compare(b) })
+	return
+}
+
+// atMinuteOfWeek returns the wall clock time, which is not the same as adding minutes when daylight saving time changes.
+func atMinuteOfWeek(weekStart time.Time, minuteOfWeek int) time.Time {
+	day := weekStart.AddDate(0, 0, minuteOfWeek/minutesPerDay)
+	m := minuteOfWeek % minutesPerDay
+	return time.Date(day.Year(), day.Month(), day.Day(), m/60, m%60, 0, 0, weekStart.Location())
+}
+
+// icsTimezoneID returns the IANA name of the location or empty if unknown.
+func icsTimezoneID(loc *time.Location) string {
+	if name := loc.String(); name != "Local" && name != "UTC" {
+		return name
+	}
+	return ""
+}
+
+// icsTime returns the property parameters and value, e.g. ;TZID=Europe/Amsterdam:20240401T080000
+func icsTime(t time.Time, tzid string) string {
+	if tzid == "" {
+		return ":" + t.UTC().Format(icsTimeLayout) + "Z"
+	}
+	return ";TZID=" + tzid + ":" + t.Format(icsTimeLayout)
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func icsText(s string) string { return icsTextEscaper.Replace(s) }
+
+// writeVTimezoneOn writes the timezone definition using the transitions in the year.
+func writeVTimezoneOn(out *icsLineWriter, loc *time.Location, tzid string, year int) {
+	type transition struct {
+		at             time.Time // first instant with the new offset
+		fromOffset, to int
+		name           string
+	}
+	transitions := []transition{}
+	t := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	_, offset := t.Zone()
+	for t.Year() == year {
+		next := t.Add(time.Hour)
+		name, nextOffset := next.Zone()
+		if nextOffset != offset {
+			transitions = append(transitions, transition{at: next, fromOffset: offset, to: nextOffset, name: name})
+			offset = nextOffset
+		}
+		t = next
+	}
+	out.line("BEGIN:VTIMEZONE")
+	out.line("TZID:" + tzid)
+	if len(transitions) == 0 {
+		name, offset := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+		out.line("BEGIN:STANDARD")
+		out.line("DTSTART:19700101T000000")
+		out.line("TZOFFSETFROM:" + icsOffset(offset))
+		out.line("TZOFFSETTO:" + icsOffset(offset))
+		out.line("TZNAME:" + name)
+		out.line("END:STANDARD")
+	}
+	for _, each := range transitions {
+		kind := "STANDARD"
+		if each.to > each.fromOffset {
+			kind = "DAYLIGHT"
+		}
+		// onset in the wall clock time before the transition
+		onset := each.at.In(time.FixedZone("", each.fromOffset))
+		n := (onset.Day()-1)/7 + 1
+		if onset.Day()+7 > daysIn(onset.Month(), onset.Year()) {
+			n = -1
+		}
+		out.line("BEGIN:" + kind)
+		out.line("DTSTART:" + onset.Format(icsTimeLayout))
+		out.line(fmt.Sprintf("RRULE:FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", onset.Month(), n, strings.ToUpper(onset.Weekday().String()[:2])))
+		out.line("TZOFFSETFROM:" + icsOffset(each.fromOffset))
+		out.line("TZOFFSETTO:" + icsOffset(each.to))
+		out.line("TZNAME:" + each.name)
+		out.line("END:" + kind)
+	}
+	out.line("END:VTIMEZONE")
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// icsOffset returns e.g. +0200
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// icsLineWriter writes content lines with CRLF, folded at 75 octets.
+type icsLineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (l *icsLineWriter) line(s string) {
+	max := 75
+	for l.err == nil {
+		if len(s) <= max {
+			_, l.err = l.w.WriteString(s + "\r\n")
+			return
+		}
+		cut := max
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		_, l.err = l.w.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		max = 74 // continuation lines start with a space
+	}
+}
+
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
@@ -0,0 +1,104 @@
+package mac
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICSWriter(t *testing.T) {
+	if err := SetTimezone("Europe/Amsterdam"); err != nil {
+		t.Skip("no timezone database", err)
+	}
+	defer func() { userLocation = time.Local }()
+
+	wp := new(WeekPlan)
+	for _, each := range []ServicePlan{
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}, TagValue: "running=0 8 1-5. stopped=0 18 1-5. count=2."},
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/test/api"}, TagValue: "running=0 8 1-5. stopped=0 18 1-5."},
+	} {
+		each.Validate()
+		wp.AddServicePlan(each)
+	}
+	wednesday := time.Date(2024, 4, 3, 12, 0, 0, 0, userLocation)
+	christmas := time.Date(2024, 12, 25, 0, 0, 0, 0, userLocation)
+	buf := new(bytes.Buffer)
+	wp.Exceptions = []time.Time{christmas}
+	w := ICSWriter{Cluster: "dev", Now: wednesday}
+	if err := w.WriteOn(wp, buf); err != nil {
+		t.Fatal(err)
+	}
+	ics := buf.String()
+	for _, each := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"TZID:Europe/Amsterdam\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240331T020000\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20241027T030000\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\n",
+		"DTSTART;TZID=Europe/Amsterdam:20240401T080000\r\nDTEND;TZID=Europe/Amsterdam:20240401T180000\r\nRRULE:FREQ=WEEKLY\r\n",
+		"EXDATE;TZID=Europe/Amsterdam:20241225T080000\r\n",
+		"SUMMARY:api running (2)\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, each) {
+			t.Errorf("missing %q in\n%s", each, ics)
+		}
+	}
+	if got, want := strings.Count(ics, "BEGIN:VEVENT"), 5; got != want {
+		t.Errorf("got %v events want %v", got, want)
+	}
+	if got, want := strings.Count(ics, "EXDATE"), 1; got != want {
+		t.Errorf("got %v exceptions want %v", got, want)
+	}
+}
+
+func TestICSWriterWeekWrap(t *testing.T) {
+	svc := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/night"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 22 6. stopped=0 6 0."}
+	sp.Validate()
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	list := joinWeekWrap(wp.RunningWindows(svc))
+	want := RunningWindow{Start: 6*minutesPerDay + 22*60, End: minutesPerWeek + 6*60, DesiredCount: 1}
+	if len(list) != 1 || list[0] != want {
+		t.Errorf("got %v want %v", list, want)
+	}
+}
+
+func TestICSLineFolding(t *testing.T) {
+	buf := new(bytes.Buffer)
+	out := &icsLineWriter{w: bufio.NewWriter(buf)}
+	out.line("DESCRIPTION:" + strings.Repeat("x", 200))
+	out.w.Flush()
+	for _, each := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(each) > 75 {
+			t.Errorf("line too long: %d", len(each))
+		}
+	}
+}
+
+func TestICSWriterExceptionOfOvernightWindow(t *testing.T) {
+	SetTimezone("UTC")
+	defer func() { userLocation = time.Local }()
+	svc := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/night"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 22 1-5. stopped=0 6 2-6."}
+	sp.Validate()
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	// the window of Tuesday 22:00 overlaps Wednesday, the one of Wednesday 22:00 starts on it
+	wp.Exceptions = []time.Time{time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)}
+	buf := new(bytes.Buffer)
+	w := ICSWriter{Now: time.Date(2024, 4, 3, 12, 0, 0, 0, time.UTC)}
+	if err := w.WriteOn(wp, buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{"EXDATE:20241224T220000Z\r\n", "EXDATE:20241225T220000Z\r\n"} {
+		if !strings.Contains(buf.String(), each) {
+			t.Errorf("missing %q in\n%s", each, buf.String())
+		}
+	}
+	if got, want := strings.Count(buf.String(), "EXDATE"), 2; got != want {
+		t.Errorf("got %v exceptions want %v", got, want)
+	}
+}
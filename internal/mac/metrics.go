@@ -0,0 +1,175 @@
+package mac
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Metrics collects what the executor observed and did, for exposition in the Prometheus text format.
+// Metric names and labels are part of the public interface; see metrics_test.go.
+type Metrics struct {
+	mu               sync.Mutex
+	servicesManaged  int
+	servicesDisabled int
+	desiredTasks     map[metricService]int
+	runningTasks     map[metricService]int
+	runningFraction  map[metricService]float32
+	transitions      map[metricTransition]int
+	failures         map[string]int // by error class
+	applyRuns        int
+	applyDuration    time.Duration // of the last apply
+}
+
+type metricService struct {
+	cluster, service string
+}
+
+type metricTransition struct {
+	cluster, action string
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		desiredTasks:    map[metricService]int{},
+		runningTasks:    map[metricService]int{},
+		runningFraction: map[metricService]float32{},
+		transitions:     map[metricTransition]int{},
+		failures:        map[string]int{},
+	}
+}
+
+func (m *Metrics) observePlans(plans []*ServicePlan) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servicesManaged, m.servicesDisabled = 0, 0
+	clear(m.desiredTasks)
+	clear(m.runningTasks)
+	clear(m.runningFraction)
+	for _, each := range plans {
+		if each.Disabled {
+			m.servicesDisabled++
+		} else {
+			m.servicesManaged++
+		}
+	}
+}
+
+func (m *Metrics) observeService(plan *ServicePlan, desired, running int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricService{cluster: plan.ClusterName(), service: plan.Name()}
+	m.desiredTasks[key] = desired
+	m.runningTasks[key] = running
+	m.runningFraction[key] = plan.PercentageRunning()
+}
+
+func (m *Metrics) observeChange(change ServiceChange) {
+	if change.Action == ActionNone {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if change.Err != nil {
+		m.failures[errorClass(change.Err)]++
+		return
+	}
+	m.transitions[metricTransition{cluster: change.ClusterName(), action: change.Action}]++
+}
+
+func (m *Metrics) observeApply(duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applyRuns++
+	m.applyDuration = duration
+}
+
+// errorClass returns the AWS error code, e.g. AccessDeniedException, or "other".
+func errorClass(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() != "" {
+		return apiErr.ErrorCode()
+	}
+	return "other"
+}
+
+// WriteOn writes all metrics in the Prometheus text exposition format (version 0.0.4).
+func (m *Metrics) WriteOn(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := new(strings.Builder)
+	header := func(name, typ, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	header("moneypenny_services_managed", "gauge", "Number of services with an enabled plan.")
+	fmt.Fprintf(b, "moneypenny_services_managed %d\n", m.servicesManaged)
+	header("moneypenny_services_disabled", "gauge", "Number of services with a disabled plan.")
+	fmt.Fprintf(b, "moneypenny_services_disabled %d\n", m.servicesDisabled)
+
+	header("moneypenny_service_desired_tasks", "gauge", "Number of tasks the schedule wants running.")
+	for _, k := range sortedServiceKeys(m.desiredTasks) {
+		fmt.Fprintf(b, "moneypenny_service_desired_tasks{%s} %d\n", k.labels(), m.desiredTasks[k])
+	}
+	header("moneypenny_service_running_tasks", "gauge", "Number of tasks observed for the service.")
+	for _, k := range sortedServiceKeys(m.runningTasks) {
+		fmt.Fprintf(b, "moneypenny_service_running_tasks{%s} %d\n", k.labels(), m.runningTasks[k])
+	}
+	header("moneypenny_service_running_fraction", "gauge", "Estimated fraction of the week the service is running according to its plan.")
+	for _, k := range sortedServiceKeys(m.runningFraction) {
+		fmt.Fprintf(b, "moneypenny_service_running_fraction{%s} %g\n", k.labels(), m.runningFraction[k])
+	}
+
+	header("moneypenny_transitions_total", "counter", "Number of state changes performed by apply.")
+	transitions := []metricTransition{}
+	for k := range m.transitions {
+		transitions = append(transitions, k)
+	}
+	slices.SortFunc(transitions, func(a, b metricTransition) int {
+		return strings.Compare(a.cluster+"/"+a.action, b.cluster+"/"+b.action)
+	})
+	for _, k := range transitions {
+		fmt.Fprintf(b, "moneypenny_transitions_total{cluster=%s,action=%s} %d\n", labelValue(k.cluster), labelValue(k.action), m.transitions[k])
+	}
+	header("moneypenny_apply_failures_total", "counter", "Number of failed state changes by error class.")
+	classes := []string{}
+	for k := range m.failures {
+		classes = append(classes, k)
+	}
+	slices.Sort(classes)
+	for _, k := range classes {
+		fmt.Fprintf(b, "moneypenny_apply_failures_total{class=%s} %d\n", labelValue(k), m.failures[k])
+	}
+
+	header("moneypenny_apply_runs_total", "counter", "Number of apply runs.")
+	fmt.Fprintf(b, "moneypenny_apply_runs_total %d\n", m.applyRuns)
+	header("moneypenny_apply_duration_seconds", "gauge", "Duration of the last apply run.")
+	fmt.Fprintf(b, "moneypenny_apply_duration_seconds %g\n", m.applyDuration.Seconds())
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (k metricService) labels() string {
+	return fmt.Sprintf("cluster=%s,service=%s", labelValue(k.cluster), labelValue(k.service))
+}
+
+// labelEscaper escapes as the Prometheus text format requires, which differs from Go quoting for other characters.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns the quoted and escaped label value.
+func labelValue(s string) string { return `"` + labelEscaper.Replace(s) + `"` }
+
+func sortedServiceKeys[V any](m map[metricService]V) (keys []metricService) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b metricService) int {
+		return strings.Compare(a.cluster+"/"+a.service, b.cluster+"/"+b.service)
+	})
+	return
+}
@@ -0,0 +1,115 @@
+package mac
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func newTestPlan(t *testing.T, arn, tag string) *ServicePlan {
+	t.Helper()
+	sp := &ServicePlan{Service: Service{ARN: arn}, TagValue: tag}
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+func TestMetricsWriteOn(t *testing.T) {
+	m := NewMetrics()
+	api := newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/api", "running=0 0 0-6.")
+	off := newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/off", "running=0 0 0-6.")
+	off.Disabled = true
+	m.observePlans([]*ServicePlan{api, off})
+	m.observeService(api, 1, 0)
+	m.observeChange(ServiceChange{Service: api.Service, Action: ActionStart})
+	m.observeChange(ServiceChange{Service: api.Service, Action: ActionStop, Err: &smithy.GenericAPIError{Code: "AccessDeniedException"}})
+	m.observeApply(1500 * time.Millisecond)
+
+	b := new(strings.Builder)
+	if err := m.WriteOn(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{
+		"# TYPE moneypenny_services_managed gauge\nmoneypenny_services_managed 1\n",
+		"moneypenny_services_disabled 1\n",
+		`moneypenny_service_desired_tasks{cluster="dev",service="api"} 1` + "\n",
+		`moneypenny_service_running_tasks{cluster="dev",service="api"} 0` + "\n",
+		`moneypenny_service_running_fraction{cluster="dev",service="api"} 1` + "\n",
+		"# TYPE moneypenny_transitions_total counter\n",
+		`moneypenny_transitions_total{cluster="dev",action="start"} 1` + "\n",
+		`moneypenny_apply_failures_total{class="AccessDeniedException"} 1` + "\n",
+		"moneypenny_apply_runs_total 1\n",
+		"moneypenny_apply_duration_seconds 1.5\n",
+	} {
+		if !strings.Contains(b.String(), each) {
+			t.Errorf("missing %q in:\n%s", each, b.String())
+		}
+	}
+}
+
+func TestMetricsErrorClass(t *testing.T) {
+	if got, want := errorClass(&smithy.GenericAPIError{Code: "ThrottlingException"}), "ThrottlingException"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := errorClass(smithy.NewErrParamRequired("x")), "other"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorApplyMetrics(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService("arn:aws:ecs:eu-central-1:9111111:service/dev/api", 0, nil)
+	fake.addService("arn:aws:ecs:eu-central-1:9111111:service/dev/worker", 2, nil)
+	plans := []*ServicePlan{
+		newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/api", "running=0 0 0-6."),
+		newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/worker", "stopped=0 0 0-6."),
+	}
+	ex := NewPlanExecutor(fake, plans)
+	if err := ex.Plan(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if err := ex.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(fake.updates, ","), "api=1,worker=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	changes := ex.Changes()
+	if got, want := len(changes), 2; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := changes[0].Action, ActionStart; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	b := new(strings.Builder)
+	ex.Metrics().WriteOn(b)
+	for _, each := range []string{
+		`moneypenny_transitions_total{cluster="dev",action="start"} 1`,
+		`moneypenny_transitions_total{cluster="dev",action="stop"} 1`,
+		"moneypenny_apply_runs_total 1\n",
+	} {
+		if !strings.Contains(b.String(), each) {
+			t.Errorf("missing %q in:\n%s", each, b.String())
+		}
+	}
+}
+
+func TestMetricsLabelValue(t *testing.T) {
+	for _, each := range []struct{ in, want string }{
+		{"dev", `"dev"`},
+		{`a\b`, `"a\\b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"two\nlines", `"two\nlines"`},
+		{"tab\tand ü", "\"tab\tand ü\""}, // not escaped, unlike %q
+	} {
+		if got := labelValue(each.in); got != each.want {
+			t.Errorf("got %s want %s", got, each.want)
+		}
+	}
+}
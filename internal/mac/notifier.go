@@ -0,0 +1,199 @@
+package mac
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/emicklei/tre"
+)
+
+// Kinds of webhook notifiers
+const (
+	NotifierJSON  = "json"
+	NotifierSlack = "slack"
+	NotifierTeams = "teams"
+)
+
+// Notifier is told about the changes after each apply.
+type Notifier interface {
+	Notify(ctx context.Context, summary ChangeSummary) error
+}
+
+// ChangeSummary is the input of the message templates.
+type ChangeSummary struct {
+	Time     time.Time
+	Changes  []ServiceChange // only those that changed a service or failed
+	Warnings []StopWarning   // services that will be stopped soon
+}
+
+// NewChangeSummary returns the summary of the changes that did something, or failed.
+// A deferred stop is left out because it is repeated by each apply until the rollout is done; the stop itself is not.
+func NewChangeSummary(when time.Time, changes []ServiceChange) ChangeSummary {
+	s := ChangeSummary{Time: when}
+	for _, each := range changes {
+		if (each.Action != ActionNone && each.Action != ActionDefer) || each.Err != nil {
+			s.Changes = append(s.Changes, each)
+		}
+	}
+	return s
+}
+
+// Errors returns the changes that failed.
+func (s ChangeSummary) Errors() (list []ServiceChange) {
+	for _, each := range s.Changes {
+		if each.Err != nil {
+			list = append(list, each)
+		}
+	}
+	return
+}
+
+// DefaultNotifyTemplate is the text/template for the message text.
+const DefaultNotifyTemplate = `{{range .Warnings}}{{.}}{{with .SnoozeURL}}, snooze: {{.}}{{end}}
+{{end}}{{with .Changes}}moneypenny applied {{len .}} change(s){{with $.Errors}}, {{len .}} failed{{end}}
+{{range .}}- {{.}}
+{{end}}{{end}}`
+
+// WebhookNotifier posts the summary to an URL, as generic JSON, a Slack incoming webhook message or a Teams card.
+type WebhookNotifier struct {
+	kind     string
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+// NewWebhookNotifier returns a notifier; an empty text template means DefaultNotifyTemplate.
+func NewWebhookNotifier(kind, url, text string) (*WebhookNotifier, error) {
+	switch kind {
+	case NotifierJSON, NotifierSlack, NotifierTeams:
+	default:
+		return nil, fmt.Errorf("unknown notifier kind %q, expected json, slack or teams", kind)
+	}
+	if text == "" {
+		text = DefaultNotifyTemplate
+	}
+	tmpl, err := template.New(kind).Parse(text)
+	if err != nil {
+		return nil, tre.New(err, "parse notify template fail")
+	}
+	return &WebhookNotifier{kind: kind, url: url, template: tmpl, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// ParseNotifiers returns the notifiers of a comma separated list of kind=url, e.g. slack=https://hooks.slack.com/services/...
+// If templateFile is not empty then its content is the message template.
+func ParseNotifiers(spec, templateFile string) (list []Notifier, err error) {
+	if spec == "" {
+		return
+	}
+	text := ""
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	for _, each := range strings.Split(spec, ",") {
+		kind, url, ok := strings.Cut(strings.TrimSpace(each), "=")
+		if !ok || url == "" {
+			return nil, fmt.Errorf("expected kind=url, got %q", each)
+		}
+		n, err := NewWebhookNotifier(kind, url, text)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, summary ChangeSummary) error {
+	text := new(strings.Builder)
+	if err := n.template.Execute(text, summary); err != nil {
+		return tre.New(err, "notify template exec fail")
+	}
+	body, err := json.Marshal(n.payload(summary, text.String()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notify %s failed with status %s", n.kind, resp.Status)
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) payload(summary ChangeSummary, text string) any {
+	switch n.kind {
+	case NotifierSlack:
+		return map[string]any{"text": text}
+	case NotifierTeams:
+		return map[string]any{
+			"type": "message",
+			"attachments": []any{map[string]any{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body": []any{
+						map[string]any{"type": "TextBlock", "text": "moneypenny", "weight": "Bolder", "size": "Medium"},
+						map[string]any{"type": "TextBlock", "text": text, "wrap": true},
+					},
+				},
+			}},
+		}
+	}
+	changes := []map[string]any{}
+	for _, each := range summary.Changes {
+		c := map[string]any{
+			"cluster":       each.ClusterName(),
+			"service":       each.Name(),
+			"action":        each.Action,
+			"task-count":    each.TaskCount,
+			"desired-count": each.DesiredCount,
+		}
+		if each.Err != nil {
+			c["error"] = each.Err.Error()
+		}
+		changes = append(changes, c)
+	}
+	warnings := []map[string]any{}
+	for _, each := range summary.Warnings {
+		warnings = append(warnings, map[string]any{
+			"cluster":    each.ClusterName(),
+			"service":    each.Name(),
+			"stop-at":    each.StopAt.Format(time.RFC3339),
+			"snooze-url": each.SnoozeURL,
+		})
+	}
+	return map[string]any{"time": summary.Time.Format(time.RFC3339), "text": text, "changes": changes, "warnings": warnings}
+}
+
+// notifyAll calls each notifier and returns all their errors.
+func notifyAll(ctx context.Context, notifiers []Notifier, summary ChangeSummary) error {
+	var errs []error
+	for _, each := range notifiers {
+		if err := each.Notify(ctx, summary); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
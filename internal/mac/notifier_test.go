@@ -0,0 +1,138 @@
+package mac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookReceiver returns a test server that keeps the bodies of all posts.
+func webhookReceiver(t *testing.T, status int) (*httptest.Server, *[]string) {
+	t.Helper()
+	bodies := new([]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(data))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func testChangeSummary() ChangeSummary {
+	return NewChangeSummary(time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC), []ServiceChange{
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}, Action: ActionStart, DesiredCount: 1},
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/web"}, Action: ActionNone},
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/batch"}, Action: ActionDefer, TaskCount: 1},
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/job"}, Action: ActionStop, TaskCount: 2, Err: errors.New("denied")},
+	})
+}
+
+func TestNotifierSlack(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusOK)
+	n, err := NewWebhookNotifier(NotifierSlack, srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testChangeSummary()); err != nil {
+		t.Fatal(err)
+	}
+	msg := map[string]string{}
+	json.Unmarshal([]byte((*bodies)[0]), &msg)
+	want := "moneypenny applied 2 change(s), 1 failed\n- start dev/api (0 -> 1)\n- stop dev/job (2 -> 0) failed: denied\n"
+	if got := msg["text"]; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestNotifierTeams(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusAccepted)
+	n, _ := NewWebhookNotifier(NotifierTeams, srv.URL, "{{range .Changes}}{{.Name}} {{end}}")
+	if err := n.Notify(context.Background(), testChangeSummary()); err != nil {
+		t.Fatal(err)
+	}
+	for _, each := range []string{`"AdaptiveCard"`, `"text":"api job "`} {
+		if !strings.Contains((*bodies)[0], each) {
+			t.Errorf("missing %s in %s", each, (*bodies)[0])
+		}
+	}
+}
+
+func TestNotifierJSON(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusOK)
+	n, _ := NewWebhookNotifier(NotifierJSON, srv.URL, "")
+	if err := n.Notify(context.Background(), testChangeSummary()); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Time    string
+		Changes []map[string]any
+	}
+	json.Unmarshal([]byte((*bodies)[0]), &msg)
+	if got, want := msg.Time, "2024-04-01T08:00:00Z"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(msg.Changes), 2; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := msg.Changes[1]["error"], "denied"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestNotifierFailedStatus(t *testing.T) {
+	srv, _ := webhookReceiver(t, http.StatusForbidden)
+	n, _ := NewWebhookNotifier(NotifierJSON, srv.URL, "")
+	if err := n.Notify(context.Background(), testChangeSummary()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseNotifiers(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "message.tmpl")
+	os.WriteFile(tmpl, []byte("{{len .Changes}}"), 0644)
+	list, err := ParseNotifiers("slack=https://hooks.example.com/a, teams=https://example.com/b?x=1", tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(list), 2; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if _, err := ParseNotifiers("pager=https://example.com", ""); err == nil {
+		t.Error("expected unknown kind error")
+	}
+	if _, err := ParseNotifiers("https://example.com", ""); err == nil {
+		t.Error("expected kind=url error")
+	}
+}
+
+func TestExecutorApplyNotifies(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusOK)
+	fake := newFakeECS()
+	fake.addService("arn:aws:ecs:eu-central-1:9111111:service/dev/api", 0, nil)
+	ex := NewPlanExecutor(fake, []*ServicePlan{
+		newTestPlan(t, "arn:aws:ecs:eu-central-1:9111111:service/dev/api", "running=0 0 0-6."),
+	})
+	n, _ := NewWebhookNotifier(NotifierSlack, srv.URL, "")
+	ex.AddNotifier(n)
+	ex.Apply()
+	// second apply has nothing to change
+	ex.Apply()
+	if got, want := len(*bodies), 1; got != want {
+		t.Fatalf("got %v notifications want %v", got, want)
+	}
+	if !strings.Contains((*bodies)[0], "start dev/api") {
+		t.Errorf("unexpected message %s", (*bodies)[0])
+	}
+}
@@ -0,0 +1,481 @@
+package mac
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Routes and cookies of the OIDC login flow
+const (
+	oidcCallbackPath  = "/oidc/callback"
+	oidcSessionCookie = "moneypenny-session"
+	oidcStateCookie   = "moneypenny-oidc-state"
+)
+
+// oidcLeeway is the allowed clock difference with the identity provider.
+const oidcLeeway = time.Minute
+
+// OIDCConfig tells how to validate bearer tokens and, if ClientID is set, how to log in with the identity provider.
+type OIDCConfig struct {
+	Issuer       string            // e.g. https://login.example.com, serves /.well-known/openid-configuration
+	Audience     string            // expected aud claim; the ClientID if empty
+	ClientID     string            // of the authorization code flow
+	ClientSecret string            // of the authorization code flow
+	RedirectURL  string            // e.g. https://controls.example.com/oidc/callback
+	GroupsClaim  string            // claim with the groups of the user; groups if empty
+	Roles        map[string]string // role by group
+}
+
+// OIDCConfigFromEnv returns the config of the OIDC_* variables; ok is false if OIDC_ISSUER is not set.
+// OIDC_ROLES has the role of each group, e.g. platform=admin,developers=operator.
+func OIDCConfigFromEnv(getenv func(string) string) (config OIDCConfig, ok bool, err error) {
+	config = OIDCConfig{
+		Issuer:       getenv("OIDC_ISSUER"),
+		Audience:     getenv("OIDC_AUDIENCE"),
+		ClientID:     getenv("OIDC_CLIENT_ID"),
+		ClientSecret: getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  getenv("OIDC_REDIRECT_URL"),
+		GroupsClaim:  getenv("OIDC_GROUPS_CLAIM"),
+		Roles:        map[string]string{},
+	}
+	if config.Issuer == "" {
+		return config, false, nil
+	}
+	for _, each := range strings.Split(getenv("OIDC_ROLES"), ",") {
+		if strings.TrimSpace(each) == "" {
+			continue
+		}
+		group, role, found := strings.Cut(each, "=")
+		if _, known := roleLevels[strings.TrimSpace(role)]; !found || !known {
+			return config, true, fmt.Errorf("invalid OIDC_ROLES entry %q, expected group=viewer, group=operator or group=admin", each)
+		}
+		config.Roles[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+	if config.ClientID != "" && config.RedirectURL == "" {
+		return config, true, errors.New("OIDC_REDIRECT_URL is required with OIDC_CLIENT_ID")
+	}
+	return config, true, nil
+}
+
+// OIDC authenticates users with bearer JWTs or a session cookie from the login flow of an identity provider.
+type OIDC struct {
+	config     OIDCConfig
+	httpClient *http.Client
+	now        func() time.Time // time.Now unless testing
+
+	mutex        sync.Mutex
+	discovery    *oidcDiscovery
+	keys         map[string]crypto.PublicKey // by kid
+	keysFetched  time.Time                   // start of the last fetch of the key set
+	keysFetching chan struct{}               // closed when the fetch in progress is done, nil if none
+}
+
+// jwksRefreshInterval is the minimum time between fetches of the key set, such that tokens with unknown kids do not flood the provider.
+const jwksRefreshInterval = time.Minute
+
+// oidcDiscovery is the part of the provider configuration used by moneypenny.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the claims of a token used by moneypenny.
+type oidcClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"` // string or list
+	Expires           int64           `json:"exp"`
+	NotBefore         int64           `json:"nbf"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+func NewOIDC(config OIDCConfig) *OIDC {
+	if config.Audience == "" {
+		config.Audience = config.ClientID
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &OIDC{config: config, httpClient: &http.Client{Timeout: 10 * time.Second}, now: time.Now}
+}
+
+// Authenticate returns a handler that requires a valid bearer token or session and passes the user in the request context.
+// Without either, a page request is redirected to the login of the identity provider if a client is configured.
+func (o *OIDC) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == oidcCallbackPath && o.config.ClientID != "" {
+			o.serveCallback(w, r)
+			return
+		}
+		raw := ""
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			raw = bearer
+		} else if cookie, err := r.Cookie(oidcSessionCookie); err == nil {
+			raw = cookie.Value
+		}
+		if raw == "" {
+			if o.config.ClientID != "" && r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, apiPrefix) {
+				o.redirectToLogin(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="moneypenny"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		user, err := o.VerifyToken(r.Context(), raw, "")
+		if err != nil {
+			slog.Warn("invalid token", "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="moneypenny", error="invalid_token"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		ctx := WithAccount(r.Context(), user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// VerifyToken checks the signature, issuer, audience and expiry of a JWT and returns its user with the role of its groups.
+// If nonce is not empty then the token must have it.
+func (o *OIDC) VerifyToken(ctx context.Context, raw, nonce string) (*User, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	key, err := o.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := oidcClaims{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	now := o.now()
+	if claims.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if !hasAudience(claims.Audience, o.config.Audience) {
+		return nil, fmt.Errorf("unexpected audience %s", claims.Audience)
+	}
+	if claims.Expires == 0 || now.After(time.Unix(claims.Expires, 0).Add(oidcLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(oidcLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, errors.New("unexpected nonce")
+	}
+	user := &User{Name: claims.Subject}
+	if claims.Email != "" {
+		user.Name = claims.Email
+	} else if claims.PreferredUsername != "" {
+		user.Name = claims.PreferredUsername
+	}
+	user.Role = o.roleOf(parts[1])
+	if user.Role == "" {
+		return nil, fmt.Errorf("no role for the groups of %s", user.Name)
+	}
+	return user, nil
+}
+
+// roleOf returns the highest role of the groups in the claims, empty if none.
+func (o *OIDC) roleOf(claimsPart string) string {
+	all := map[string]json.RawMessage{}
+	decodeJWTPart(claimsPart, &all)
+	groups := []string{}
+	if err := json.Unmarshal(all[o.config.GroupsClaim], &groups); err != nil {
+		// a single group
+		group := ""
+		json.Unmarshal(all[o.config.GroupsClaim], &group)
+		groups = append(groups, group)
+	}
+	role := ""
+	for _, each := range groups {
+		if r, ok := o.config.Roles[each]; ok && roleLevels[r] > roleLevels[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+func hasAudience(aud json.RawMessage, want string) bool {
+	single := ""
+	if json.Unmarshal(aud, &single) == nil {
+		return single == want
+	}
+	list := []string{}
+	json.Unmarshal(aud, &list)
+	return slices.Contains(list, want)
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("key is not an EC key or signature has wrong size")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q, expected RS256 or ES256", alg)
+}
+
+// publicKey returns the key of the provider with the kid; the key set is fetched again for an unknown kid,
+// at most once per jwksRefreshInterval and without holding the mutex. Concurrent requests wait for the fetch in progress.
+func (o *OIDC) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	o.mutex.Lock()
+	key, ok := o.keys[kid]
+	done, fetch := o.keysFetching, false
+	if !ok && done == nil && o.now().Sub(o.keysFetched) >= jwksRefreshInterval {
+		done, fetch = make(chan struct{}), true
+		o.keysFetching, o.keysFetched = done, o.now()
+	}
+	o.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if fetch {
+		keys, err := o.fetchKeys(ctx)
+		o.mutex.Lock()
+		if err == nil {
+			o.keys = keys
+		}
+		o.keysFetching = nil
+		o.mutex.Unlock()
+		close(done)
+		if err != nil {
+			return nil, err
+		}
+	} else if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// fetchKeys returns the supported keys of the key set of the provider by kid.
+func (o *OIDC) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	o.mutex.Lock()
+	discovery, err := o.discover(ctx)
+	o.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}{}
+	if err := o.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch key set: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, each := range set.Keys {
+		switch each.Kty {
+		case "RSA":
+			n, nerr := base64.RawURLEncoding.DecodeString(each.N)
+			e, eerr := base64.RawURLEncoding.DecodeString(each.E)
+			if nerr != nil || eerr != nil {
+				slog.Warn("skipping malformed RSA key", "kid", each.Kid)
+				continue
+			}
+			keys[each.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, xerr := base64.RawURLEncoding.DecodeString(each.X)
+			y, yerr := base64.RawURLEncoding.DecodeString(each.Y)
+			if each.Crv != "P-256" || xerr != nil || yerr != nil {
+				slog.Warn("skipping unsupported EC key", "kid", each.Kid, "crv", each.Crv)
+				continue
+			}
+			keys[each.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// discover returns the provider configuration, fetched once. Call with the mutex locked.
+func (o *OIDC) discover(ctx context.Context) (*oidcDiscovery, error) {
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	discovery := new(oidcDiscovery)
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if discovery.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match %q", discovery.Issuer, o.config.Issuer)
+	}
+	o.discovery = discovery
+	return discovery, nil
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// redirectToLogin sends the browser to the identity provider; the state is kept in a cookie and also used as nonce.
+func (o *OIDC) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	o.mutex.Lock()
+	discovery, err := o.discover(r.Context())
+	o.mutex.Unlock()
+	if err != nil {
+		slog.Error("oidc login failed", "err", err)
+		http.Error(w, "login unavailable", http.StatusBadGateway)
+		return
+	}
+	random := make([]byte, 16)
+	rand.Read(random)
+	state := base64.RawURLEncoding.EncodeToString(random)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: state + "|" + r.URL.RequestURI(), Path: oidcCallbackPath,
+		MaxAge: 600, HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", o.config.ClientID)
+	q.Set("redirect_uri", o.config.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", state)
+	http.Redirect(w, r, discovery.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// serveCallback exchanges the code for an ID token and keeps that token in the session cookie.
+func (o *OIDC) serveCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	state, returnTo, _ := strings.Cut(func() string {
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}(), "|")
+	if state == "" || r.URL.Query().Get("state") != state {
+		slog.Warn("oidc callback with unexpected state")
+		http.Error(w, "unexpected state, please log in again", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	o.mutex.Lock()
+	discovery, err := o.discover(r.Context())
+	o.mutex.Unlock()
+	if err != nil {
+		slog.Error("oidc callback failed", "err", err)
+		http.Error(w, "login unavailable", http.StatusBadGateway)
+		return
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", r.URL.Query().Get("code"))
+	form.Set("redirect_uri", o.config.RedirectURL)
+	form.Set("client_id", o.config.ClientID)
+	form.Set("client_secret", o.config.ClientSecret)
+	resp, err := o.httpClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		slog.Error("oidc token request failed", "err", err)
+		http.Error(w, "login failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&tokens) != nil || tokens.IDToken == "" {
+		slog.Error("oidc token request failed", "status", resp.Status)
+		http.Error(w, "login failed", http.StatusBadGateway)
+		return
+	}
+	user, err := o.VerifyToken(r.Context(), tokens.IDToken, state)
+	if err != nil {
+		slog.Warn("invalid id token", "err", err)
+		http.Error(w, "login failed: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	slog.Info("logged in", "user", user.Name, "role", user.Role)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCallbackPath, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: oidcSessionCookie, Value: tokens.IDToken, Path: "/",
+		HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
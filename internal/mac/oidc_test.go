@@ -0,0 +1,271 @@
+package mac
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubIdP is an identity provider with one RSA key that issues the token of its claims.
+type stubIdP struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	claims     map[string]any
+	keyFetches atomic.Int32
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.keyFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "abc" || r.FormValue("client_secret") != "shh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.token(t, "test", idp.claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *stubIdP) token(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (idp *stubIdP) validClaims() map[string]any {
+	return map[string]any{
+		"iss":    idp.server.URL,
+		"sub":    "u123",
+		"aud":    []string{"moneypenny"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "bond@example.com",
+		"groups": []string{"developers", "platform"},
+	}
+}
+
+func (idp *stubIdP) oidc() *OIDC {
+	return NewOIDC(OIDCConfig{
+		Issuer:       idp.server.URL,
+		ClientID:     "moneypenny",
+		ClientSecret: "shh",
+		RedirectURL:  "https://controls.example.com/oidc/callback",
+		Roles:        map[string]string{"developers": RoleViewer, "platform": RoleOperator},
+	})
+}
+
+func TestOIDCVerifyToken(t *testing.T) {
+	idp := newStubIdP(t)
+	user, err := idp.oidc().VerifyToken(context.Background(), idp.token(t, "test", idp.validClaims()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := user.Name, "bond@example.com"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := user.Role, RoleOperator; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestOIDCVerifyTokenInvalid(t *testing.T) {
+	idp := newStubIdP(t)
+	oidc := idp.oidc()
+	for name, change := range map[string]func(claims map[string]any){
+		"issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"audience": func(c map[string]any) { c["aud"] = "other" },
+		"expired":  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":   func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"no role":  func(c map[string]any) { c["groups"] = []string{"sales"} },
+	} {
+		claims := idp.validClaims()
+		change(claims)
+		if _, err := oidc.VerifyToken(context.Background(), idp.token(t, "test", claims), ""); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := oidc.VerifyToken(context.Background(), idp.token(t, "other", idp.validClaims()), ""); err == nil {
+		t.Error("unknown kid: expected error")
+	}
+	token := idp.token(t, "test", idp.validClaims())
+	parts := strings.Split(token, ".")
+	tampered, _ := json.Marshal(map[string]any{"iss": idp.server.URL, "aud": "moneypenny", "exp": time.Now().Add(time.Hour).Unix(), "groups": "platform", "sub": "m"})
+	if _, err := oidc.VerifyToken(context.Background(), parts[0]+"."+base64.RawURLEncoding.EncodeToString(tampered)+"."+parts[2], ""); err == nil {
+		t.Error("tampered: expected error")
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"test"}`)) + "." + parts[1] + "."
+	if _, err := oidc.VerifyToken(context.Background(), none, ""); err == nil {
+		t.Error("alg none: expected error")
+	}
+}
+
+func TestOIDCUnknownKeyFetchesOncePerInterval(t *testing.T) {
+	idp := newStubIdP(t)
+	oidc := idp.oidc()
+	now := time.Now()
+	oidc.now = func() time.Time { return now }
+	for range 3 {
+		if _, err := oidc.VerifyToken(context.Background(), idp.token(t, "other", idp.validClaims()), ""); err == nil {
+			t.Error("unknown kid: expected error")
+		}
+	}
+	if got, want := int(idp.keyFetches.Load()), 1; got != want {
+		t.Errorf("got %v fetches want %v", got, want)
+	}
+	now = now.Add(jwksRefreshInterval)
+	oidc.VerifyToken(context.Background(), idp.token(t, "other", idp.validClaims()), "")
+	if got, want := int(idp.keyFetches.Load()), 2; got != want {
+		t.Errorf("got %v fetches want %v", got, want)
+	}
+	// known keys are not fetched again
+	if _, err := oidc.VerifyToken(context.Background(), idp.token(t, "test", idp.validClaims()), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := int(idp.keyFetches.Load()), 2; got != want {
+		t.Errorf("got %v fetches want %v", got, want)
+	}
+}
+
+func TestOIDCAuthenticateBearer(t *testing.T) {
+	idp := newStubIdP(t)
+	var got *User
+	handler := idp.oidc().Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = accountFrom(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/services", nil)
+	r.Header.Set("Authorization", "Bearer "+idp.token(t, "test", idp.validClaims()))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got == nil || got.Name != "bond@example.com" {
+		t.Fatalf("got %v want bond@example.com", got)
+	}
+	// api without token is not redirected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/services", nil))
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newStubIdP(t)
+	handler := idp.oidc().Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFrom(r.Context())))
+	}))
+	// redirect to the identity provider
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?do=audit", nil))
+	if got, want := w.Code, http.StatusFound; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	login, _ := url.Parse(w.Header().Get("Location"))
+	if got, want := login.Path, "/authorize"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	state := login.Query().Get("state")
+	stateCookie := w.Result().Cookies()[0]
+
+	// callback with the code
+	callback := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=abc&state="+state, nil)
+	callback.AddCookie(stateCookie)
+	claims := idp.validClaims()
+	claims["aud"] = "moneypenny"
+	claims["nonce"] = state
+	idp.claims = claims
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, callback)
+	if got, want := w.Code, http.StatusFound; got != want {
+		t.Fatalf("got %v want %v: %s", got, want, w.Body.String())
+	}
+	if got, want := w.Header().Get("Location"), "/?do=audit"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	var session *http.Cookie
+	for _, each := range w.Result().Cookies() {
+		if each.Name == oidcSessionCookie {
+			session = each
+		}
+	}
+	if session == nil {
+		t.Fatal("missing session cookie")
+	}
+
+	// session cookie authenticates
+	page := httptest.NewRequest(http.MethodGet, "/", nil)
+	page.AddCookie(session)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, page)
+	if got, want := w.Body.String(), "bond@example.com"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// callback with another state is rejected
+	forged := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=abc&state=forged", nil)
+	forged.AddCookie(stateCookie)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, forged)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestOIDCConfigFromEnv(t *testing.T) {
+	env := map[string]string{"OIDC_ISSUER": "https://login.example.com", "OIDC_ROLES": "platform=admin, developers=viewer"}
+	config, ok, err := OIDCConfigFromEnv(func(k string) string { return env[k] })
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if got, want := config.Roles["developers"], RoleViewer; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	env["OIDC_ROLES"] = "platform=root"
+	if _, _, err := OIDCConfigFromEnv(func(k string) string { return env[k] }); err == nil {
+		t.Error("expected error for unknown role")
+	}
+	if _, ok, _ := OIDCConfigFromEnv(func(string) string { return "" }); ok {
+		t.Error("expected no config without issuer")
+	}
+}
@@ -1,47 +1,167 @@
 package mac
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	_ "embed"
-
-	"github.com/aws/aws-sdk-go-v2/service/ecs"
 )
 
 var serviceTagName = "moneypenny"
 
 type PlanExecutor struct {
-	dryRun   bool
-	weekPlan *WeekPlan
-	plans    []*ServicePlan
-	client   *ecs.Client
+	dryRun      bool
+	weekPlan    *WeekPlan
+	plans       []*ServicePlan
+	client      ECSAPI
+	metrics     *Metrics
+	changes     []ServiceChange // of the last plan or apply
+	emf         io.Writer       // if set, apply writes Embedded Metric Format logs
+	notifiers   []Notifier      // told about the changes of each apply
+	warnings    []StopWarning   // of the last apply
+	state       *ServiceState
+	statusURL   string           // used in links to snooze a stop
+	clock       func() time.Time // time.Now unless testing
+	auditStore  AuditStore       // if set, actions are recorded
+	deployments DeploymentPolicy // for stops during a rollout
+	actor       string           // who is acting, for the audit
 }
 
-func NewPlanExecutor(client *ecs.Client, plans []*ServicePlan) *PlanExecutor {
+func NewPlanExecutor(client ECSAPI, plans []*ServicePlan) *PlanExecutor {
 	wp := new(WeekPlan)
 	for _, each := range plans {
 		wp.AddServicePlan(*each)
 	}
-	return &PlanExecutor{weekPlan: wp, dryRun: true, plans: plans, client: client}
+	return &PlanExecutor{weekPlan: wp, dryRun: true, plans: plans, client: client, metrics: NewMetrics(),
+		state: NewServiceState(NewMemoryStateStore()), clock: time.Now, deployments: DefaultDeploymentPolicy}
+}
+
+// WithPlans returns a new executor for the client and plans with the same settings, stores and metrics,
+// e.g. to use plans that are fetched again for each request of a long running server.
+func (p *PlanExecutor) WithPlans(client ECSAPI, plans []*ServicePlan) *PlanExecutor {
+	e := NewPlanExecutor(client, plans)
+	e.metrics, e.emf, e.notifiers, e.state, e.statusURL = p.metrics, p.emf, p.notifiers, p.state, p.statusURL
+	e.clock, e.auditStore, e.deployments, e.actor = p.clock, p.auditStore, p.deployments, p.actor
+	e.weekPlan.Exceptions = p.weekPlan.Exceptions
+	return e
+}
+
+// Metrics returns the metrics collected by Plan and Apply.
+func (p *PlanExecutor) Metrics() *Metrics { return p.metrics }
+
+// SetMetrics replaces the metrics, e.g. to keep counting across executors.
+func (p *PlanExecutor) SetMetrics(m *Metrics) { p.metrics = m }
+
+// SetEMFOutput makes Apply write CloudWatch Embedded Metric Format log lines on w, e.g. os.Stdout in a Lambda.
+func (p *PlanExecutor) SetEMFOutput(w io.Writer) { p.emf = w }
+
+// AddNotifier adds a notifier that is told about the changes after each apply, if any.
+func (p *PlanExecutor) AddNotifier(n Notifier) { p.notifiers = append(p.notifiers, n) }
+
+// SetStatusURL sets the URL of the status page, used to create snooze links in stop warnings.
+func (p *PlanExecutor) SetStatusURL(statusURL string) { p.statusURL = statusURL }
+
+// SetAudit makes the executor record all actions in the store, on behalf of who.
+func (p *PlanExecutor) SetAudit(store AuditStore, who string) {
+	p.auditStore = store
+	p.actor = who
+}
+
+// SetStateStore sets where the state of services is kept across runs, such as snoozes; in memory if not set.
+func (p *PlanExecutor) SetStateStore(store StateStore) { p.state = NewServiceState(store) }
+
+// SetExceptions sets the dates on which no service is running, e.g. holidays.
+// Apply stops the running services on these dates and the calendar leaves out their running periods.
+func (p *PlanExecutor) SetExceptions(dates []time.Time) { p.weekPlan.Exceptions = dates }
+
+// SetDeploymentPolicy sets what to do with a scheduled stop of a service with a rollout in progress.
+func (p *PlanExecutor) SetDeploymentPolicy(policy DeploymentPolicy) { p.deployments = policy }
+
+// SetFilter restricts the executor to the plans of the services that match the filter.
+func (p *PlanExecutor) SetFilter(filter ServiceFilter) {
+	if filter.IsEmpty() {
+		return
+	}
+	p.plans = filter.Plans(p.plans)
+	p.weekPlan = new(WeekPlan)
+	for _, each := range p.plans {
+		p.weekPlan.AddServicePlan(*each)
+	}
 }
 
+// AuditStore returns the store set by SetAudit, if any.
+func (p *PlanExecutor) AuditStore() AuditStore { return p.auditStore }
+
+// Actor returns who is acting, for the audit.
+func (p *PlanExecutor) Actor() string { return p.actor }
+
+// Changes returns the outcome per service of the last Plan or Apply.
+func (p *PlanExecutor) Changes() []ServiceChange { return p.changes }
+
+// logContext remembers the default logger without and with the action of the last setLogContext.
+var logContext struct {
+	mutex            sync.Mutex
+	base, withAction *slog.Logger
+}
+
+// setLogContext makes the default logger add the action; it replaces the action of a previous call
+// such that a long running process does not accumulate attributes.
 func setLogContext(action string) {
-	clog := slog.With("x", action)
-	slog.SetDefault(clog)
+	logContext.mutex.Lock()
+	defer logContext.mutex.Unlock()
+	base := slog.Default()
+	if base == logContext.withAction {
+		base = logContext.base
+	}
+	logContext.base, logContext.withAction = base, base.With("x", action)
+	slog.SetDefault(logContext.withAction)
+}
+
+// saveLogContext returns a function that restores the default logger and the action of setLogContext,
+// e.g. after a request that logged to its own handler.
+func saveLogContext() func() {
+	logContext.mutex.Lock()
+	defer logContext.mutex.Unlock()
+	saved, base, withAction := slog.Default(), logContext.base, logContext.withAction
+	return func() {
+		logContext.mutex.Lock()
+		defer logContext.mutex.Unlock()
+		slog.SetDefault(saved)
+		logContext.base, logContext.withAction = base, withAction
+	}
 }
 
 func (p *PlanExecutor) Plan() error {
 	setLogContext("plan")
+	p.dryRun = true
 	return p.exec()
 }
 func (p *PlanExecutor) Apply() error {
 	setLogContext("apply")
 	p.dryRun = false
-	return p.exec()
+	if err := p.exec(); err != nil {
+		return err
+	}
+	if p.emf != nil {
+		if err := (EMFWriter{}).WriteOn(p.changes, p.emf); err != nil {
+			slog.Error("failed to write metrics log", "err", err)
+		}
+	}
+	summary := NewChangeSummary(p.clock(), p.changes)
+	summary.Warnings = p.warnings
+	if len(p.notifiers) > 0 && (len(summary.Changes) > 0 || len(summary.Warnings) > 0) {
+		if err := notifyAll(context.Background(), p.notifiers, summary); err != nil {
+			slog.Error("failed to notify", "err", err)
+		}
+	}
+	return nil
 }
 
 func (p *PlanExecutor) Start(serviceARN string) error {
@@ -50,7 +170,15 @@ func (p *PlanExecutor) Start(serviceARN string) error {
 	if serviceARN == "" {
 		return errors.New("no service ARN was given")
 	}
-	return StartService(p.client, Service{ARN: serviceARN}, 1)
+	service := Service{ARN: serviceARN}
+	previous := p.previousCount(service)
+	err := StartService(p.client, service, 1)
+	if err == nil {
+		p.clearDeferredStop(service)
+		p.clearDrainStart(service)
+	}
+	p.audit(ActionStart, service, previous, 1, AuditSourceManual, err, "")
+	return err
 }
 
 func (p *PlanExecutor) Stop(serviceARN string) error {
@@ -59,7 +187,38 @@ func (p *PlanExecutor) Stop(serviceARN string) error {
 	if serviceARN == "" {
 		return errors.New("no service ARN was given")
 	}
-	return StopService(p.client, Service{ARN: serviceARN})
+	service := Service{ARN: serviceARN}
+	strategy := p.stopStrategyOf(service)
+	previous := p.previousCount(service)
+	err := p.stopService(service, strategy)
+	if err == nil {
+		p.clearDeferredStop(service)
+	}
+	p.audit(ActionStop, service, previous, 0, AuditSourceManual, err, "using "+strategy.String())
+	return err
+}
+
+// Snooze postpones scheduled stops of the service for a number of minutes, SnoozeMinutes if empty.
+func (p *PlanExecutor) Snooze(serviceARN string, minutesInput string) error {
+	setLogContext("snooze")
+	if serviceARN == "" {
+		return errors.New("no service ARN was given")
+	}
+	minutes := SnoozeMinutes
+	if minutesInput != "" {
+		m, err := strconv.Atoi(minutesInput)
+		if err != nil || m < 1 || m > minutesPerDay {
+			return fmt.Errorf("snooze minutes %q out of range 1..%d", minutesInput, minutesPerDay)
+		}
+		minutes = m
+	}
+	until := p.clock().Add(time.Duration(minutes) * time.Minute)
+	slog.Info("snoozing stop of service", "arn", serviceARN, "until", until)
+	service := Service{ARN: serviceARN}
+	err := p.state.SetTime(service, snoozedStateKey, until)
+	count := p.previousCount(service)
+	p.audit(ActionSnooze, service, count, count, AuditSourceManual, err, "")
+	return err
 }
 
 func (p *PlanExecutor) ChangeTaskCount(serviceARN string, countInput string) error {
@@ -75,7 +234,15 @@ func (p *PlanExecutor) ChangeTaskCount(serviceARN string, countInput string) err
 	if err != nil {
 		return err
 	}
-	return ChangeTaskCountOfService(p.client, Service{ARN: serviceARN}, count)
+	service := Service{ARN: serviceARN}
+	previous := p.previousCount(service)
+	err = ChangeTaskCountOfService(p.client, service, count)
+	if err == nil {
+		p.clearDeferredStop(service)
+		p.clearDrainStart(service)
+	}
+	p.audit(ActionChangeCount, service, previous, count, AuditSourceManual, err, "")
+	return err
 }
 
 func (p *PlanExecutor) Report() error {
@@ -96,54 +263,136 @@ func (p *PlanExecutor) Schedule() error {
 	return NewReporter(p).Schedule()
 }
 
+func (p *PlanExecutor) Timeline() error {
+	setLogContext("timeline")
+	slog.Info("write timeline")
+	return NewReporter(p).Timeline()
+}
+
+func (p *PlanExecutor) ExportICS(cluster, service string) error {
+	setLogContext("export")
+	slog.Info("write ics", "cluster", cluster, "service", service, "exceptions", len(p.weekPlan.Exceptions))
+	return NewReporter(p).ICS(cluster, service)
+}
+
+// snoozedUntil returns the end of the snooze if the running service must be stopped but is snoozed.
+func (p *PlanExecutor) snoozedUntil(service Service, event ScheduledEvent, isRunning bool, now time.Time) (time.Time, error) {
+	if event.DesiredState == Running || !isRunning {
+		return time.Time{}, nil
+	}
+	until, err := p.state.GetTime(service, snoozedStateKey)
+	if err != nil || !until.After(now) {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
 func (p *PlanExecutor) exec() error {
-	now := time.Now().In(userLocation)
+	start := time.Now()
+	now := p.clock().In(userLocation)
 	slog.Info("executing", "time", now, "location", os.Getenv("TIME_ZONE"))
+	p.changes = []ServiceChange{}
+	p.warnings = []StopWarning{}
+	p.metrics.observePlans(p.plans)
 	for _, each := range p.plans {
 		if each.Disabled {
-			slog.Warn("disabled plan, skipping", "service", each.ARN)
+			slog.Warn("disabled plan, skipping", "service", each.ARN, "source", each.SourceLabel())
 			continue
 		}
 		event, ok := p.weekPlan.LastScheduledEventAt(each.Service, now)
 		if ok {
-			howMany, lastStatus := ServiceStatus(p.client, each.Service)
-			clog := slog.With("name", each.Service.Name(), "state", lastStatus, "crons", each.TagValue, "task-count", howMany)
+			info, err := DescribeServiceInfo(p.client, each.Service)
+			if err != nil {
+				slog.Error("failed to describe service", "name", each.Service.Name(), "err", err)
+				p.changes = append(p.changes, ServiceChange{Service: each.Service, Action: ActionNone, DesiredCount: event.DesiredCount, Applied: !p.dryRun, Err: err})
+				continue
+			}
+			p.metrics.observeService(each, event.DesiredCount, info.RunningCount)
+			clog := slog.With("name", each.Service.Name(), "state", info.State(), "crons", each.TagValue,
+				"desired-count", info.DesiredCount, "running-count", info.RunningCount, "pending-count", info.PendingCount, "source", each.SourceLabel())
+			change := ServiceChange{Service: each.Service, Action: ActionNone, TaskCount: info.RunningCount, DesiredCount: event.DesiredCount, Applied: !p.dryRun}
 
-			if lastStatus == Unknown {
-				clog.Info("service has unknown last status, assume it is stopped")
-				lastStatus = Stopped
+			action, reason := decideAction(info, event)
+			if until, _ := p.snoozedUntil(each.Service, event, info.DesiredCount > 0, now); !until.IsZero() {
+				clog.Info("service must be stopped but is snoozed", "until", until)
+				action = ActionNone
+			} else if action == ActionNone {
+				clog.Info(reason)
+			} else {
+				clog.Info(">> CHANGE: "+reason, "desired", event.DesiredCount)
+			}
+			if action == ActionStop && info.IsRollingOut() {
+				action, change.Note = p.stopDuringRollout(info, now)
+			}
+			strategy := each.StopStrategy()
+			// a drain is over once the tasks are gone or the service was started again, also outside moneypenny
+			if !p.dryRun && (info.RunningCount == 0 || info.DesiredCount > 0) {
+				p.clearDrainStart(each.Service)
+			}
+			if action == ActionNone && info.DesiredCount == 0 && info.RunningCount > 0 && p.drainTimedOut(each.Service, strategy, now) {
+				clog.Info(">> CHANGE: service is still draining after the timeout", "drain-timeout", strategy.DrainTimeout)
+				action = ActionStop
+				change.Note = fmt.Sprintf("tasks still running after drain timeout of %s", strategy.DrainTimeout)
+				strategy = DefaultStopStrategy
 			}
-			isRunning := lastStatus == Running
-			if event.DesiredState != Running && isRunning {
-				clog.Info(">> CHANGE: service is running but must be stopped")
-				if p.dryRun {
-					continue
+			change.Action = action
+			if action == ActionStop {
+				change.StopStrategy = strategy.String()
+			}
+			if !p.dryRun {
+				switch action {
+				case ActionStop:
+					if change.Err = p.stopService(each.Service, strategy); change.Err != nil {
+						clog.Error("failed to stop service", "err", change.Err, "stop", strategy)
+					}
+					if change.Err == nil && strategy.Mode == StopForce {
+						p.clearDrainStart(each.Service)
+					}
+				case ActionStart:
+					if change.Err = StartService(p.client, each.Service, event.DesiredCount); change.Err != nil {
+						clog.Error("failed to start service", "err", change.Err)
+					} else {
+						p.clearDrainStart(each.Service)
+					}
+				case ActionRescale:
+					if change.Err = ChangeTaskCountOfService(p.client, each.Service, event.DesiredCount); change.Err != nil {
+						clog.Error("failed to change task count of service", "err", change.Err)
+					} else {
+						p.clearDrainStart(each.Service)
+					}
 				}
-				if err := StopService(p.client, each.Service); err != nil {
-					clog.Error("failed to stop service", "err", err)
+				// a failed stop is still deferred, otherwise the service is no longer waiting for a rollout
+				if action != ActionDefer && !(action == ActionStop && change.Err != nil) {
+					p.clearDeferredStop(each.Service)
 				}
-			} else if event.DesiredState == Running && !isRunning {
-				clog.Info(">> CHANGE: service must be running")
-				if p.dryRun {
-					continue
+			}
+			if !p.dryRun && change.Action != ActionNone {
+				source := AuditSourceSchedule
+				if each.Source == SourceFile {
+					source = AuditSourceOverride
 				}
-				if err := StartService(p.client, each.Service, event.DesiredCount); err != nil {
-					clog.Error("failed to start service", "err", err)
+				newCount := event.DesiredCount
+				switch change.Action {
+				case ActionStop:
+					newCount = 0
+				case ActionDefer:
+					newCount = info.DesiredCount
 				}
-			} else {
-				if isRunning && event.DesiredCount != howMany {
-					clog.Info(">> CHANGE: service must have different task count", "desired", event.DesiredCount)
-					if p.dryRun {
-						continue
-					}
-					if err := ChangeTaskCountOfService(p.client, each.Service, event.DesiredCount); err != nil {
-						clog.Error("failed to change task count of service", "err", err)
-					}
-				} else {
-					clog.Info("service is in expected state")
+				p.audit(change.Action, each.Service, info.DesiredCount, newCount, source, change.Err, change.Note)
+			}
+			if !p.dryRun && event.DesiredState == Running {
+				if warning, ok := p.stopWarning(each, now); ok {
+					p.warnings = append(p.warnings, warning)
 				}
 			}
+			p.changes = append(p.changes, change)
+			if !p.dryRun {
+				p.metrics.observeChange(change)
+			}
 		}
 	}
+	if !p.dryRun {
+		p.metrics.observeApply(time.Since(start))
+	}
 	return nil
 }
@@ -0,0 +1,66 @@
+package mac
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSetLogContextReplacesAction(t *testing.T) {
+	setLogContext("apply")
+	base := logContext.base
+	setLogContext("plan")
+	setLogContext("plan")
+	if logContext.base != base {
+		t.Error("expected the logger without action as base")
+	}
+	if slog.Default() != logContext.withAction {
+		t.Error("expected the logger with action as default")
+	}
+}
+
+func TestSaveLogContextRestoresAction(t *testing.T) {
+	setLogContext("apply")
+	base := logContext.base
+	restore := saveLogContext()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	setLogContext("stop")
+	restore()
+	setLogContext("plan")
+	if logContext.base != base {
+		t.Error("expected the logger without action as base")
+	}
+}
+
+func TestWithPlansKeepsSettings(t *testing.T) {
+	ex := NewPlanExecutor(newFakeECS(), nil)
+	ex.SetStatusURL("https://example.com")
+	ex.SetAudit(NewFileAuditStore(t.TempDir()+"/audit.jsonl"), "scheduler")
+	ex.SetExceptions([]time.Time{time.Date(2024, 12, 25, 0, 0, 0, 0, userLocation)})
+	plans := []*ServicePlan{newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5.")}
+	other := ex.WithPlans(newFakeECS(), plans)
+	if got, want := len(other.plans), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if other.Metrics() != ex.Metrics() || other.state != ex.state || other.AuditStore() != ex.AuditStore() {
+		t.Error("expected shared metrics, state and audit")
+	}
+	if got, want := other.statusURL, ex.statusURL; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(other.weekPlan.Exceptions), 1; got != want {
+		t.Errorf("got %v exceptions want %v", got, want)
+	}
+}
+
+func TestApplyStopsOnException(t *testing.T) {
+	ex, _, now := newExecutorTest(t, officeHours, 1, mondayAt(12, 0))
+	ex.SetExceptions([]time.Time{*now})
+	if err := ex.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ex.Changes()[0].Action, ActionStop; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
@@ -3,17 +3,20 @@ package mac
 import (
 	"context"
 	"log/slog"
+	"slices"
 
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/aws/aws-sdk-go/aws"
 )
 
 type PlanFetcher struct {
-	client *ecs.Client
-	Plans  []*ServicePlan
+	client   ECSAPI
+	Plans    []*ServicePlan
+	services []types.Service // discovered by FetchServicePlans or ExpandServicePlans
 }
 
-func NewPlanFetcher(client *ecs.Client) *PlanFetcher {
+func NewPlanFetcher(client ECSAPI) *PlanFetcher {
 	return &PlanFetcher{
 		client: client,
 	}
@@ -42,11 +45,14 @@ func (p *PlanFetcher) FetchServicePlans() error {
 		slog.Error("fetchServicesAndPlans fail", "err", err)
 		return err
 	}
+	p.services = allServices
 	for _, each := range allServices {
 		input := TagValue(each, serviceTagName)
 		sp := new(ServicePlan)
 		sp.ARN = *each.ServiceArn
 		sp.TagValue = input // can be empty
+		sp.Source = SourceTag
+		sp.Tags = serviceTags(each)
 		if IsTagValueReference(input) {
 			slog.Debug("find tag value by service", "service", *each.ServiceArn, "moneypenny", input)
 			input = ResolveTagValue(allServices, input)
@@ -64,3 +70,56 @@ func (p *PlanFetcher) FetchServicePlans() error {
 	}
 	return nil
 }
+
+// MergeLocalServicePlans merges the local plans into the fetched plans, see MergeServicePlans.
+// Local plans for services that have no moneypenny tag are checked to exist.
+func (p *PlanFetcher) MergeLocalServicePlans(local []*ServicePlan) error {
+	tagged := map[string]bool{}
+	for _, each := range p.Plans {
+		tagged[each.ARN] = true
+	}
+	untagged := []*ServicePlan{}
+	for _, each := range local {
+		if !tagged[each.ARN] {
+			untagged = append(untagged, each)
+		}
+	}
+	merged := MergeServicePlans(p.Plans, local)
+	// CheckServicePlans replaces p.Plans
+	if err := p.CheckServicePlans(untagged); err != nil {
+		return err
+	}
+	p.Plans = merged
+	p.setServiceTags(local)
+	return nil
+}
+
+// setServiceTags sets the tags of the discovered services on the plans that have none.
+func (p *PlanFetcher) setServiceTags(plans []*ServicePlan) {
+	byARN := map[string]types.Service{}
+	for _, each := range p.services {
+		byARN[stringValue(each.ServiceArn)] = each
+	}
+	for _, each := range plans {
+		if svc, ok := byARN[each.ARN]; ok && each.Tags == nil {
+			each.Tags = serviceTags(svc)
+		}
+	}
+}
+
+// ExpandServicePlans returns the plans with each selector plan replaced by plans for the matching services.
+// Services are discovered if that was not done by FetchServicePlans.
+func (p *PlanFetcher) ExpandServicePlans(plans []*ServicePlan) ([]*ServicePlan, error) {
+	if !slices.ContainsFunc(plans, func(sp *ServicePlan) bool { return sp.Selector != nil }) {
+		return plans, nil
+	}
+	if p.services == nil {
+		allServices, err := AllServices(p.client)
+		if err != nil {
+			slog.Error("discover services fail", "err", err)
+			return plans, err
+		}
+		p.services = allServices
+	}
+	return ExpandServicePlans(plans, p.services), nil
+}
@@ -1,16 +1,34 @@
 package mac
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "embed"
+
+	"gopkg.in/yaml.v3"
 )
 
+//go:embed assets/service-plans.schema.json
+var PlansSchema []byte
+
 type PlanLoader struct {
 	Plans      []*ServicePlan
-	configFile string
+	configFile string // file, file://, ssm:// or s3:// source
+	ssmClient  ssmAPI // created when needed
+	s3Client   s3API  // created when needed
 }
 
+// NewPlanLoader returns a loader for the plans document from a source; see readPlansSource.
 func NewPlanLoader(configFile string) *PlanLoader {
 	return &PlanLoader{
 		configFile: configFile,
@@ -22,24 +40,205 @@ func (p *PlanLoader) LoadServicePlans() error {
 		slog.Info("no local service plans")
 		return nil
 	} else {
-		data, err := os.ReadFile(p.configFile)
+		data, err := p.readPlansSource(context.Background())
 		if err != nil {
 			slog.Error("read fail", "err", err)
 			return err
 		}
-		err = json.Unmarshal(data, &p.Plans)
+		plans, err := ParseServicePlans(p.configFile, data)
 		if err != nil {
 			slog.Error("parse fail", "err", err)
 			return err
 		}
-		for _, each := range p.Plans {
+		p.Plans = plans
+	}
+	slog.Info("read service plans", "file", p.configFile, "count", len(p.Plans))
+	return nil
+}
+
+// PlanError is an error in a service plans document with its position.
+type PlanError struct {
+	File         string
+	Line, Column int // 1-based, 0 if unknown
+	Err          error
+}
+
+func (e *PlanError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	}
+	if e.Column == 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+func (e *PlanError) Unwrap() error { return e.Err }
+
+// ParseServicePlans decodes and validates a service plans document.
+// The name is used for error positions; a name ending with .yaml or .yml is decoded as YAML, with .json as JSON.
+// Without these extensions, a document that starts with [ is decoded as JSON, otherwise as YAML.
+// Unknown fields are rejected.
+func ParseServicePlans(name string, data []byte) ([]*ServicePlan, error) {
+	var plans []*ServicePlan
+	var positions [][2]int // line,column of each plan
+	var err error
+	if isYAMLPlans(name, data) {
+		plans, positions, err = decodeYAMLPlans(name, data)
+	} else {
+		plans, positions, err = decodeJSONPlans(name, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, each := range plans {
+		line, col := positions[i][0], positions[i][1]
+		if each == nil {
+			return nil, &PlanError{File: name, Line: line, Column: col, Err: errors.New("missing service plan")}
+		}
+		each.Source = SourceFile
+		if each.Selector != nil {
+			slog.Info("validating service plan", "select", each.Selector.String(), "cron", each.TagValue)
+			if each.ARN != "" {
+				return nil, &PlanError{File: name, Line: line, Column: col, Err: errors.New("either service-arn or select can be given, not both")}
+			}
+			if err := each.Selector.Validate(); err != nil {
+				return nil, &PlanError{File: name, Line: line, Column: col, Err: err}
+			}
+		} else {
 			slog.Info("validating service plan", "name", each.ARN, "cron", each.TagValue)
-			if err := each.Validate(); err != nil {
-				slog.Error("validate fail", "err", err)
-				return err
+			if each.ARN == "" {
+				return nil, &PlanError{File: name, Line: line, Column: col, Err: errors.New("missing service-arn or select")}
 			}
 		}
+		if each.TagValue != "" && len(each.StateChanges) > 0 {
+			return nil, &PlanError{File: name, Line: line, Column: col, Err: errors.New("either moneypenny or state-changes can be given, not both")}
+		}
+		if err := each.Validate(); err != nil {
+			return nil, &PlanError{File: name, Line: line, Column: col, Err: err}
+		}
 	}
-	slog.Info("read service plans", "file", p.configFile, "count", len(p.Plans))
-	return nil
+	return plans, nil
+}
+
+func isYAMLPlans(name string, data []byte) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+}
+
+func decodeJSONPlans(name string, data []byte) (plans []*ServicePlan, positions [][2]int, err error) {
+	positioned := func(offset int64, err error) error {
+		line, col := lineColumnAt(data, offset)
+		return &PlanError{File: name, Line: line, Column: col, Err: err}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, positioned(dec.InputOffset(), err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, nil, positioned(dec.InputOffset(), errors.New("expected an array of service plans"))
+	}
+	for dec.More() {
+		start := dec.InputOffset()
+		start += int64(len(data[start:]) - len(bytes.TrimLeft(data[start:], " \t\r\n,")))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, nil, positioned(syntaxErr.Offset, err)
+			}
+			return nil, nil, positioned(start, err)
+		}
+		sp := new(ServicePlan)
+		elem := json.NewDecoder(bytes.NewReader(raw))
+		elem.DisallowUnknownFields()
+		if err := elem.Decode(sp); err != nil {
+			return nil, nil, positioned(start+jsonErrorOffset(raw, err), err)
+		}
+		plans = append(plans, sp)
+		line, col := lineColumnAt(data, start)
+		positions = append(positions, [2]int{line, col})
+	}
+	if _, err := dec.Token(); err != nil { // closing bracket
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, positioned(dec.InputOffset(), err)
+	}
+	end := dec.InputOffset()
+	if _, err := dec.Token(); err != io.EOF {
+		end += int64(len(data[end:]) - len(bytes.TrimLeft(data[end:], " \t\r\n")))
+		return nil, nil, positioned(end, errors.New("unexpected data after the array of service plans"))
+	}
+	return
+}
+
+// jsonErrorOffset returns the offset in raw where the decode error was detected, 0 if unknown.
+func jsonErrorOffset(raw []byte, err error) int64 {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Offset
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if i := bytes.Index(raw, []byte(field)); i != -1 {
+			return int64(i)
+		}
+	}
+	return 0
+}
+
+// lineColumnAt returns the 1-based line and column of a byte offset.
+func lineColumnAt(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+var yamlLineError = regexp.MustCompile(`line (\d+): (.*)`)
+
+func decodeYAMLPlans(name string, data []byte) (plans []*ServicePlan, positions [][2]int, err error) {
+	// strict decoding reports unknown fields with their lines
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&plans); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+			return nil, nil, yamlPlanError(name, typeErr.Errors[0])
+		}
+		return nil, nil, yamlPlanError(name, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	// decode again to find the position of each plan
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, yamlPlanError(name, err.Error())
+	}
+	if len(doc.Content) == 0 {
+		return
+	}
+	for _, each := range doc.Content[0].Content {
+		positions = append(positions, [2]int{each.Line, each.Column})
+	}
+	return
+}
+
+func yamlPlanError(name, msg string) error {
+	if m := yamlLineError.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &PlanError{File: name, Line: line, Err: errors.New(m[2])}
+	}
+	return &PlanError{File: name, Err: errors.New(msg)}
 }
@@ -0,0 +1,230 @@
+package mac
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseServicePlansJSON(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "moneypenny": "running=0 8 1-5. stopped=0 18 1-5."
+    }
+]`
+	plans, err := ParseServicePlans("plans.json", []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(plans[0].StateChanges), 2; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := plans[0].Source, SourceFile; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansJSONUnknownField(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "moneypenny": "running=0 8 1-5. stopped=0 18 1-5."
+    },
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/other",
+        "moneypeny": "running=0 8 1-5. stopped=0 18 1-5."
+    }
+]`
+	_, err := ParseServicePlans("plans.json", []byte(doc))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), `plans.json:8:9: json: unknown field "moneypeny"`; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansJSONTrailingData(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "moneypenny": "running=0 8 1-5. stopped=0 18 1-5."
+    }
+]
+{}`
+	_, err := ParseServicePlans("plans.json", []byte(doc))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), "plans.json:7:1: unexpected data after the array of service plans"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansJSONUnclosed(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "moneypenny": "running=0 8 1-5. stopped=0 18 1-5."
+    }`
+	if _, err := ParseServicePlans("plans.json", []byte(doc)); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestParseServicePlansJSONBadTag(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "moneypenny": "running=0 8"
+    }
+]`
+	_, err := ParseServicePlans("plans.json", []byte(doc))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if !strings.HasPrefix(err.Error(), "plans.json:2:5: ") {
+		t.Errorf("unexpected position: %v", err)
+	}
+}
+
+func TestParseServicePlansYAML(t *testing.T) {
+	doc := `
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/name
+  moneypenny: running=0 8 1-5. stopped=0 18 1-5.
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/high-load
+  state-changes:
+    - desired-state: running
+      desired-count: 2
+      cron: 0 7 1-5
+    - desired-state: stopped
+      cron: 0 22 1-5
+`
+	plans, err := ParseServicePlans("plans.yaml", []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(plans), 2; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	run := plans[1].StateChanges[0]
+	if got, want := run.DesiredState, Running; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := run.DesiredCount, 2; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := run.CronSpec.Hour, 7; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansYAMLUnknownField(t *testing.T) {
+	doc := `
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/name
+  state-changes:
+    - desired-state: running
+      crom: 0 7 1-5
+`
+	_, err := ParseServicePlans("plans.yml", []byte(doc))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), "plans.yml:5: field crom not found in type mac.StateChange"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansYAMLBothGiven(t *testing.T) {
+	doc := `
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/name
+  moneypenny: running=0 8 1-5.
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/other
+  moneypenny: running=0 8 1-5.
+  state-changes:
+    - desired-state: running
+      cron: 0 7 1-5
+`
+	_, err := ParseServicePlans("plans.yaml", []byte(doc))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if !strings.HasPrefix(err.Error(), "plans.yaml:4:3: ") {
+		t.Errorf("unexpected position: %v", err)
+	}
+}
+
+func TestPlansSchemaMatchesServicePlan(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(PlansSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	check := func(def string, typ reflect.Type) {
+		want := jsonFieldNames(typ)
+		got := []string{}
+		for k := range schema.Defs[def].Properties {
+			got = append(got, k)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %v want %v", def, got, want)
+		}
+	}
+	check("servicePlan", reflect.TypeFor[ServicePlan]())
+	check("stateChange", reflect.TypeFor[StateChange]())
+}
+
+func jsonFieldNames(typ reflect.Type) (names []string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous {
+			names = append(names, jsonFieldNames(f.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return
+}
+
+func TestParseServicePlansYAMLSelect(t *testing.T) {
+	doc := `
+- select:
+    cluster: dev
+    service: feature-*
+    tags:
+      env: dev
+  moneypenny: running=0 8 1-5. stopped=0 18 1-5.
+`
+	plans, err := ParseServicePlans("plans.yaml", []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := plans[0].Selector.String(), "cluster=dev,service=feature-*,tag:env=dev"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseServicePlansSelectAndARN(t *testing.T) {
+	doc := `[
+    {
+        "service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+        "select": { "cluster": "dev" },
+        "moneypenny": "running=0 8 1-5."
+    }
+]`
+	if _, err := ParseServicePlans("plans.json", []byte(doc)); err == nil {
+		t.Fatal("error expected")
+	}
+}
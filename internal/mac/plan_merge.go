@@ -0,0 +1,39 @@
+package mac
+
+import "log/slog"
+
+// MergeServicePlans combines the plans fetched from the moneypenny tags with the plans loaded from a local file.
+// Precedence per service:
+//   - an enabled local plan overrides the tag plan
+//   - a disabled local plan does not override the tag plan
+//   - a local plan without a tag plan is always kept, so a disabled one is still reported
+//
+// The order of the tag plans is preserved; local plans for untagged services are appended.
+func MergeServicePlans(tagged, local []*ServicePlan) (merged []*ServicePlan) {
+	localByARN := map[string]*ServicePlan{}
+	for _, each := range local {
+		localByARN[each.ARN] = each
+	}
+	taggedARNs := map[string]bool{}
+	for _, each := range tagged {
+		taggedARNs[each.ARN] = true
+		other, ok := localByARN[each.ARN]
+		if !ok {
+			merged = append(merged, each)
+			continue
+		}
+		if other.Disabled {
+			slog.Debug("local plan is disabled, using tag plan", "service", each.ARN)
+			merged = append(merged, each)
+			continue
+		}
+		slog.Debug("local plan overrides tag plan", "service", each.ARN, "local", other.TagValue, "tag", each.TagValue)
+		merged = append(merged, other)
+	}
+	for _, each := range local {
+		if !taggedARNs[each.ARN] {
+			merged = append(merged, each)
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package mac
+
+import "testing"
+
+func TestMergeServicePlans(t *testing.T) {
+	tagged := []*ServicePlan{
+		{Service: Service{ARN: "a"}, TagValue: "running=0 8 1-5.", Source: SourceTag},
+		{Service: Service{ARN: "b"}, TagValue: "running=0 8 1-5.", Source: SourceTag},
+		{Service: Service{ARN: "c"}, TagValue: "running=0 8 1-5.", Source: SourceTag},
+	}
+	local := []*ServicePlan{
+		{Service: Service{ARN: "b"}, TagValue: "running=0 9 1-5.", Source: SourceFile},
+		{Service: Service{ARN: "c"}, TagValue: "running=0 9 1-5.", Source: SourceFile, Disabled: true},
+		{Service: Service{ARN: "d"}, TagValue: "running=0 9 1-5.", Source: SourceFile, Disabled: true},
+	}
+	merged := MergeServicePlans(tagged, local)
+	want := []struct{ arn, source string }{
+		{"a", SourceTag},
+		{"b", SourceFile},
+		{"c", SourceTag},
+		{"d", SourceFile},
+	}
+	if got, want := len(merged), len(want); got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+	for i, each := range want {
+		if got := merged[i]; got.ARN != each.arn || got.Source != each.source {
+			t.Errorf("[%d] got %s from %s want %s from %s", i, got.ARN, got.Source, each.arn, each.source)
+		}
+	}
+}
+
+func TestMergeServicePlansNoLocal(t *testing.T) {
+	tagged := []*ServicePlan{{Service: Service{ARN: "a"}, Source: SourceTag}}
+	merged := MergeServicePlans(tagged, nil)
+	if len(merged) != 1 {
+		t.Fail()
+	}
+}
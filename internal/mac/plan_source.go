@@ -0,0 +1,105 @@
+package mac
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Prefixes of the supported plans document sources
+const (
+	fileSourcePrefix = "file://"
+	ssmSourcePrefix  = "ssm://"
+	s3SourcePrefix   = "s3://"
+)
+
+// ssmAPI is the part of the SSM client used to read a plans document.
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// s3API is the part of the S3 client used to read a plans document.
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// readPlansSource returns the plans document from a local file, a SSM parameter or an S3 object.
+// Supported sources are:
+//   - path/to/file or file://path/to/file
+//   - ssm://path/to/param (the parameter /path/to/param)
+//   - s3://bucket/key
+func (p *PlanLoader) readPlansSource(ctx context.Context) ([]byte, error) {
+	source := p.configFile
+	switch {
+	case strings.HasPrefix(source, ssmSourcePrefix):
+		return p.readSSMParameter(ctx, ssmParameterName(source))
+	case strings.HasPrefix(source, s3SourcePrefix):
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(source, s3SourcePrefix), "/")
+		if !ok || bucket == "" || key == "" {
+			return nil, errors.New("expected s3://bucket/key, got " + source)
+		}
+		return p.readS3Object(ctx, bucket, key)
+	default:
+		return os.ReadFile(strings.TrimPrefix(source, fileSourcePrefix))
+	}
+}
+
+// ssmParameterName returns the name of the parameter in the source.
+// Hierarchical names always start with a slash.
+func ssmParameterName(source string) string {
+	name := strings.TrimPrefix(source, ssmSourcePrefix)
+	if strings.Contains(name, "/") && !strings.HasPrefix(name, "/") {
+		return "/" + name
+	}
+	return name
+}
+
+func (p *PlanLoader) readSSMParameter(ctx context.Context, name string) ([]byte, error) {
+	if p.ssmClient == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.ssmClient = ssm.NewFromConfig(cfg)
+	}
+	slog.Debug("reading service plans parameter", "name", name)
+	out, err := p.ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return nil, errors.New("parameter has no value: " + name)
+	}
+	return []byte(*out.Parameter.Value), nil
+}
+
+func (p *PlanLoader) readS3Object(ctx context.Context, bucket, key string) ([]byte, error) {
+	if p.s3Client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		p.s3Client = s3.NewFromConfig(cfg)
+	}
+	slog.Debug("reading service plans object", "bucket", bucket, "key", key)
+	out, err := p.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
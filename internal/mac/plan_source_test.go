@@ -0,0 +1,114 @@
+package mac
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// localSSM is a stand-in for the SSM Parameter Store
+type localSSM map[string]string
+
+func (l localSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	v, ok := l[*params.Name]
+	if !ok {
+		return nil, errors.New("ParameterNotFound: " + *params.Name)
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: params.Name, Value: aws.String(v)}}, nil
+}
+
+// localS3 is a stand-in for S3 with objects by bucket/key
+type localS3 map[string]string
+
+func (l localS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	v, ok := l[*params.Bucket+"/"+*params.Key]
+	if !ok {
+		return nil, errors.New("NoSuchKey: " + *params.Key)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(v))}, nil
+}
+
+const yamlPlansDoc = `
+- service-arn: arn:aws:ecs:eu-central-1:9111111:service/cluster/name
+  moneypenny: running=0 8 1-5. stopped=0 18 1-5.
+`
+
+const jsonPlansDoc = `[
+	{
+		"service-arn": "arn:aws:ecs:eu-central-1:9111111:service/cluster/name",
+		"moneypenny": "running=0 8 1-5. stopped=0 18 1-5."
+	}
+]`
+
+func TestLoadServicePlansFromSSM(t *testing.T) {
+	l := NewPlanLoader("ssm://dev/moneypenny/plans")
+	l.ssmClient = localSSM{"/dev/moneypenny/plans": yamlPlansDoc}
+	if err := l.LoadServicePlans(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(l.Plans), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestLoadServicePlansFromSSMMissing(t *testing.T) {
+	l := NewPlanLoader("ssm://dev/moneypenny/missing")
+	l.ssmClient = localSSM{}
+	if err := l.LoadServicePlans(); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestLoadServicePlansFromS3(t *testing.T) {
+	l := NewPlanLoader("s3://ops-bucket/moneypenny/plans")
+	l.s3Client = localS3{"ops-bucket/moneypenny/plans": jsonPlansDoc}
+	if err := l.LoadServicePlans(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(l.Plans), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestLoadServicePlansFromS3NoKey(t *testing.T) {
+	l := NewPlanLoader("s3://ops-bucket")
+	l.s3Client = localS3{}
+	if err := l.LoadServicePlans(); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestLoadServicePlansFromFileURL(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "plans.yaml")
+	if err := os.WriteFile(name, []byte(yamlPlansDoc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewPlanLoader("file://" + name)
+	if err := l.LoadServicePlans(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(l.Plans), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestSSMParameterName(t *testing.T) {
+	for _, each := range []struct{ source, name string }{
+		{"ssm://path/to/param", "/path/to/param"},
+		{"ssm:///path/to/param", "/path/to/param"},
+		{"ssm://param", "param"},
+	} {
+		if got := ssmParameterName(each.source); got != each.name {
+			t.Errorf("%s: got %v want %v", each.source, got, each.name)
+		}
+	}
+}
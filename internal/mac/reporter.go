@@ -1,16 +1,23 @@
 package mac
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	_ "embed"
+
+	"github.com/emicklei/tre"
 )
 
 type Reporter struct {
-	executor *PlanExecutor
+	executor  *PlanExecutor
+	csrfToken string        // for posting actions on the status page
+	filter    ServiceFilter // services on the status, timeline and schedule
+	refresh   time.Duration // if not zero, the status rows are updated in place this often
 }
 
 func NewReporter(exec *PlanExecutor) *Reporter {
@@ -19,6 +26,35 @@ func NewReporter(exec *PlanExecutor) *Reporter {
 	}
 }
 
+// SetCSRFToken sets the token that is posted with the actions of the status page.
+func (r *Reporter) SetCSRFToken(token string) { r.csrfToken = token }
+
+// SetRefresh makes the status page update its rows in place this often, using ?do=status-rows.
+func (r *Reporter) SetRefresh(d time.Duration) { r.refresh = d }
+
+// SetFilter sets which services are written on the status, timeline and schedule.
+func (r *Reporter) SetFilter(filter ServiceFilter) { r.filter = filter }
+
+// plans returns the plans of the executor that match the filter.
+func (r *Reporter) plans() []*ServicePlan {
+	if r.filter.IsEmpty() {
+		return r.executor.plans
+	}
+	return r.filter.Plans(r.executor.plans)
+}
+
+// weekPlan returns the week plan of the executor, or of the plans that match the filter.
+func (r *Reporter) weekPlan() *WeekPlan {
+	if r.filter.IsEmpty() {
+		return r.executor.weekPlan
+	}
+	wp := new(WeekPlan)
+	for _, each := range r.plans() {
+		wp.AddServicePlan(*each)
+	}
+	return wp
+}
+
 func (r *Reporter) Report() error {
 	rout, _ := os.Create("awscontrols-report.html")
 	defer rout.Close()
@@ -31,6 +67,10 @@ func (r *Reporter) Report() error {
 	if err := r.WriteStatusOn(rout); err != nil {
 		return err
 	}
+	fmt.Fprintln(rout, "<h2>Timeline</h2>")
+	if err := r.WriteTimelineOn(rout, time.Now()); err != nil {
+		return err
+	}
 	fmt.Fprintln(rout, "<h2>Schedule</h2>")
 	if err := r.WriteScheduleOn(rout); err != nil {
 		return err
@@ -38,6 +78,13 @@ func (r *Reporter) Report() error {
 	return r.WriteCloseHTMLOn(rout)
 }
 
+// Timeline writes the week plan as a standalone SVG file.
+func (r *Reporter) Timeline() error {
+	rout, _ := os.Create("awscontrols-timeline.svg")
+	defer rout.Close()
+	return r.WriteTimelineOn(rout, time.Time{})
+}
+
 func (r *Reporter) Schedule() error {
 	rout, _ := os.Create("awscontrols-schedule.html")
 	defer rout.Close()
@@ -82,28 +129,112 @@ func (r *Reporter) WriteCloseHTMLOn(w io.Writer) error {
 
 func (r *Reporter) WriteScheduleOn(w io.Writer) error {
 	rep := ScheduleWriter{}
-	if err := rep.WriteOn(r.executor.weekPlan, w); err != nil {
+	if err := rep.WriteOn(r.weekPlan(), w); err != nil {
 		slog.Error("schedule report failed", "err", err)
 		return err
 	}
 	return nil
 }
 
+// ICS writes the running periods of the services as an iCalendar file.
+func (r *Reporter) ICS(cluster, service string) error {
+	rout, _ := os.Create("awscontrols-schedule.ics")
+	defer rout.Close()
+	return r.WriteICSOn(rout, cluster, service)
+}
+
+// WriteICSOn writes the running periods of all services or those of a cluster and/or service name.
+func (r *Reporter) WriteICSOn(w io.Writer, cluster, service string) error {
+	rep := ICSWriter{Cluster: cluster, Service: service}
+	if err := rep.WriteOn(r.executor.weekPlan, w); err != nil {
+		slog.Error("ics write failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+// WriteTimelineOn writes the week plan as an inline SVG; if now is not zero then it is marked.
+func (r *Reporter) WriteTimelineOn(w io.Writer, now time.Time) error {
+	rep := TimelineWriter{Now: now}
+	if err := rep.WriteOn(r.weekPlan(), w); err != nil {
+		slog.Error("timeline write failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+// WriteDriftOn writes the drifts as JSON.
+func (r *Reporter) WriteDriftOn(w io.Writer, drifts []Drift) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(drifts)
+}
+
+// WriteAuditOn writes the recent audit entries matching the filter.
+func (r *Reporter) WriteAuditOn(w io.Writer, filter AuditFilter) error {
+	if r.executor.auditStore == nil {
+		fmt.Fprintln(w, "<p>no audit store configured</p>")
+		return nil
+	}
+	rep := AuditWriter{Filter: filter}
+	if err := rep.WriteOn(r.executor.auditStore, w); err != nil {
+		slog.Error("audit write failed", "err", err)
+		return err
+	}
+	return nil
+}
+
 func (r *Reporter) WriteStatusOn(w io.Writer) error {
-	rep := StatusWriter{client: r.executor.client}
-	if err := rep.WriteOn(r.executor.plans, w); err != nil {
+	if err := r.statusWriter().WriteOn(r.plans(), w); err != nil {
 		slog.Error("status writefailed", "err", err)
 		return err
 	}
 	return nil
 }
 
+// WriteStatusRowsOn writes the rendered status rows as JSON.
+func (r *Reporter) WriteStatusRowsOn(w io.Writer) error {
+	if err := r.statusWriter().WriteRowsOn(r.plans(), w); err != nil {
+		slog.Error("status rows write failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Reporter) statusWriter() *StatusWriter {
+	return &StatusWriter{client: r.executor.client, weekPlan: r.executor.weekPlan, state: r.executor.state, csrfToken: r.csrfToken, refresh: r.refresh, clock: r.executor.clock}
+}
+
+// WriteFilterOn writes the form to filter the services by cluster, service and tag.
+func (r *Reporter) WriteFilterOn(w io.Writer) error {
+	return tre.New(filterTemplate.Execute(w, r.filter), "filter template exec fail")
+}
+
+// WriteServiceOn writes the page of one service.
+func (r *Reporter) WriteServiceOn(w io.Writer, plan *ServicePlan, now time.Time) error {
+	rep := ServiceWriter{client: r.executor.client, weekPlan: r.executor.weekPlan, csrfToken: r.csrfToken}
+	if info, err := DescribeServiceInfo(r.executor.client, plan.Service); err != nil {
+		slog.Warn("failed to describe service", "name", plan.Name(), "err", err)
+	} else {
+		since, _ := r.executor.state.GetTime(plan.Service, driftSinceStateKey)
+		if drift := newDrift(r.executor.weekPlan, plan, info, since, now); drift.Kind != "" {
+			rep.drift = drift.String()
+		}
+	}
+	if err := rep.WriteOn(plan, now, w); err != nil {
+		slog.Error("service write failed", "err", err)
+		return err
+	}
+	return nil
+}
+
 func (r *Reporter) WriteControlsOn(w io.Writer) error {
 	content := `
 	<div class="controls">
 		<button class="controlsaction" type="button" onclick="location.href='?do=report'" >Report</button>
 		<button class="controlsaction preferred" type="button" onclick="location.href='?do=plan'" >Plan</button>
 		<button class="controlsaction" type="button" onclick="location.href='?do=apply'" >Apply</button>
+		<button class="controlsaction" type="button" onclick="location.href='?do=audit'" >Audit</button>
 	</div>
 `
 	fmt.Fprintln(w, content)
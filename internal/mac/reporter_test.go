@@ -5,6 +5,8 @@ import (
 )
 
 func TestReportSchedule(t *testing.T) {
+	// Report writes awscontrols-report.html in the working directory
+	t.Chdir(t.TempDir())
 	c, _ := NewECSClient()
 	e := NewPlanExecutor(c, []*ServicePlan{})
 	r := NewReporter(e)
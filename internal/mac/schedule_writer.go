@@ -54,6 +54,7 @@ func (r ScheduleWriter) WriteOn(wp *WeekPlan, w io.Writer) error {
 			td.RowClass = "stopped"
 			td.TasksCount = 0
 			td.Cron = tp.cron
+			td.PageURL = servicePageURL(tp.Service)
 			if tp.DesiredState == Running {
 				td.RowClass = "running"
 				td.TasksCount = tp.DesiredCount
@@ -70,7 +71,9 @@ func (r ScheduleWriter) WriteOn(wp *WeekPlan, w io.Writer) error {
 }
 
 type WeekData struct {
-	Days []DayData
+	Days           []DayData
+	CSRFToken      string // for posting actions
+	RefreshSeconds int    // if not zero, the status rows are updated in place this often
 }
 type DayData struct {
 	Name      string
@@ -86,8 +89,16 @@ type TimeData struct {
 	Cron        string
 	Links       []LinkData
 	Savings     string
+	Source      string
+	Drift       string       // empty if the service is as scheduled
+	Info        ServiceInfo  // actual state, only for status
+	PageURL     template.URL // of the service page
+	RowID       string       // element id of the status row
+	Next        string       // next scheduled transition, only for status
+	CSRFToken   string       // for posting actions, only for status
 }
 type LinkData struct {
-	Href  template.URL
-	Title string
+	Href   template.URL // for a link that does not change services
+	Title  string
+	Fields map[string]string // if set, the action is posted with these form fields
 }
@@ -0,0 +1,118 @@
+package mac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Actions of a scheduled event
+const (
+	ScheduledApply = "apply"
+	ScheduledPlan  = "plan"
+	ScheduledDrift = "drift"
+)
+
+// ScheduledAction is the detail of an EventBridge event (from a rule or the Scheduler) that invokes the controls,
+// e.g. {"action":"apply","cluster":"dev-*","tags":{"team":"payments"}}. The filters are those of ServiceFilter.
+type ScheduledAction struct {
+	Action           string            `json:"action,omitempty"` // apply if empty, plan or drift
+	Cluster          string            `json:"cluster,omitempty"`
+	Service          string            `json:"service,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	CorrectOlderThan string            `json:"correct-older-than,omitempty"` // only for drift, e.g. 30m
+}
+
+// ScheduledResult is the outcome of a ScheduledAction, returned to the invoker.
+type ScheduledResult struct {
+	Action   string      `json:"action"`
+	Source   string      `json:"source,omitempty"` // of the event, e.g. aws.events or aws.scheduler
+	Services int         `json:"services"`         // number of plans that matched the filters
+	Changes  []apiChange `json:"changes,omitempty"`
+	Drifts   []Drift     `json:"drifts,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// ParseScheduledEvent returns the action of an EventBridge event; ok is false if the payload is not such an event.
+// An event without detail, such as the Scheduled Event of a rule, applies the schedule to all services.
+func ParseScheduledEvent(payload []byte) (action ScheduledAction, source string, ok bool, err error) {
+	envelope := struct {
+		DetailType string          `json:"detail-type"`
+		Source     string          `json:"source"`
+		Detail     json.RawMessage `json:"detail"`
+	}{}
+	if json.Unmarshal(payload, &envelope) != nil || envelope.DetailType == "" {
+		return action, "", false, nil
+	}
+	if len(envelope.Detail) > 0 && string(envelope.Detail) != "null" {
+		if err := json.Unmarshal(envelope.Detail, &action); err != nil {
+			return action, envelope.Source, true, fmt.Errorf("invalid detail of %s event: %w", envelope.DetailType, err)
+		}
+	}
+	if action.Action == "" {
+		action.Action = ScheduledApply
+	}
+	return action, envelope.Source, true, action.Validate()
+}
+
+// Validate checks the action, the filters and the drift duration.
+func (a ScheduledAction) Validate() error {
+	switch a.Action {
+	case ScheduledApply, ScheduledPlan, ScheduledDrift:
+	default:
+		return fmt.Errorf("unknown action %q, expected apply, plan or drift", a.Action)
+	}
+	if a.CorrectOlderThan != "" {
+		if _, err := time.ParseDuration(a.CorrectOlderThan); err != nil {
+			return fmt.Errorf("invalid correct-older-than: %w", err)
+		}
+	}
+	return ServiceSelector{Cluster: a.Cluster, Service: a.Service, Tags: a.Tags}.validatePatterns()
+}
+
+// Filter returns the filter of the services this action is for.
+func (a ScheduledAction) Filter() ServiceFilter {
+	return ServiceFilter{Cluster: a.Cluster, Service: a.Service, Tags: a.Tags}
+}
+
+// Run restricts the executor to the services of the filters and performs the action.
+// The error of the result also has the errors of the services that failed.
+func (a ScheduledAction) Run(executor *PlanExecutor) ScheduledResult {
+	executor.SetFilter(a.Filter())
+	result := ScheduledResult{Action: a.Action, Services: len(executor.plans)}
+	var err error
+	errs := []error{}
+	switch a.Action {
+	case ScheduledApply, ScheduledPlan:
+		if a.Action == ScheduledApply {
+			err = executor.Apply()
+		} else {
+			err = executor.Plan()
+		}
+		for _, each := range executor.Changes() {
+			if each.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", each.Name(), each.Err))
+			}
+		}
+		result.Changes = apiChanges(executor.Changes())
+	case ScheduledDrift:
+		correctOlderThan := time.Duration(0)
+		if a.CorrectOlderThan != "" {
+			correctOlderThan, _ = time.ParseDuration(a.CorrectOlderThan) // validated
+		}
+		result.Drifts, err = executor.Drift(correctOlderThan)
+		for _, each := range result.Drifts {
+			if each.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", each.Name(), each.Err))
+			}
+		}
+	}
+	err = errors.Join(append([]error{err}, errs...)...)
+	if err != nil {
+		slog.Error("scheduled action failed", "action", a.Action, "err", err)
+		result.Error = err.Error()
+	}
+	return result
+}
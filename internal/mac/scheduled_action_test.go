@@ -0,0 +1,119 @@
+package mac
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sample of the event of an EventBridge rule with a schedule expression
+const ruleScheduledEvent = `{
+  "version": "0",
+  "id": "53dc4d37-cffa-4f76-80c9-8b7d4a4d2eaa",
+  "detail-type": "Scheduled Event",
+  "source": "aws.events",
+  "account": "123456789012",
+  "time": "2024-04-01T18:05:00Z",
+  "region": "eu-central-1",
+  "resources": ["arn:aws:events:eu-central-1:123456789012:rule/moneypenny-apply"],
+  "detail": {}
+}`
+
+// sample of the input of an EventBridge Scheduler schedule
+const schedulerEvent = `{
+  "detail-type": "Scheduled Event",
+  "source": "aws.scheduler",
+  "detail": {"action": "apply", "cluster": "dev", "tags": {"team": "payments"}}
+}`
+
+func TestParseScheduledEvent(t *testing.T) {
+	action, source, ok, err := ParseScheduledEvent([]byte(ruleScheduledEvent))
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if got, want := action.Action, ScheduledApply; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := source, "aws.events"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if !action.Filter().IsEmpty() {
+		t.Errorf("unexpected filter %v", action.Filter())
+	}
+	action, _, _, err = ParseScheduledEvent([]byte(schedulerEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := action.Filter().Query().Encode(), "cluster=dev&tag=team%3Apayments"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseScheduledEventInvalid(t *testing.T) {
+	for _, each := range []string{
+		`{"detail-type":"Scheduled Event","detail":{"action":"destroy"}}`,
+		`{"detail-type":"Scheduled Event","detail":{"action":"drift","correct-older-than":"soon"}}`,
+		`{"detail-type":"Scheduled Event","detail":{"service":"/[/"}}`,
+		`{"detail-type":"Scheduled Event","detail":"apply"}`,
+	} {
+		if _, _, ok, err := ParseScheduledEvent([]byte(each)); !ok || err == nil {
+			t.Errorf("expected error for %s", each)
+		}
+	}
+	// not events
+	for _, each := range []string{
+		`{"httpMethod":"GET","path":"/","queryStringParameters":{"do":"apply"}}`,
+		`{"version":"2.0","rawPath":"/"}`,
+		`null`,
+		``,
+	} {
+		if _, _, ok, _ := ParseScheduledEvent([]byte(each)); ok {
+			t.Errorf("unexpected event for %s", each)
+		}
+	}
+}
+
+func TestScheduledActionRun(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	other := "arn:aws:ecs:eu-central-1:9111111:service/prod/web"
+	fake.addService(other, 1, nil)
+	tag := "running=0 8 1-5. stopped=0 18 1-5."
+	ex := NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, tag), newTestPlan(t, other, tag)})
+	ex.clock = func() time.Time { return time.Date(2024, 4, 1, 19, 0, 0, 0, userLocation) } // Monday
+
+	action, _, _, _ := ParseScheduledEvent([]byte(`{"detail-type":"Scheduled Event","source":"aws.scheduler","detail":{"cluster":"prod"}}`))
+	result := action.Run(ex)
+	if got, want := result.Services, 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := strings.Join(fake.updates, ","), "web=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(result.Changes), 1; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if c := result.Changes[0]; c.Name != "web" || !c.Applied {
+		t.Errorf("got %+v want applied change of web", c)
+	}
+	if result.Error != "" {
+		t.Errorf("unexpected error %s", result.Error)
+	}
+}
+
+func TestScheduledActionRunFailedService(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	fake.updateErr = errors.New("access denied")
+	ex := NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5.")})
+	ex.clock = func() time.Time { return time.Date(2024, 4, 1, 19, 0, 0, 0, userLocation) } // Monday
+
+	result := ScheduledAction{Action: ScheduledApply}.Run(ex)
+	if got, want := result.Error, "api: access denied"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := len(result.Changes), 1; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
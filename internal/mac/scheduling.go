@@ -15,7 +15,7 @@ const Unknown = "UNKNOWN"
 
 // ECS service
 type Service struct {
-	ARN string `json:"service-arn"`
+	ARN string `json:"service-arn" yaml:"service-arn"`
 }
 
 func (s Service) Name() string {
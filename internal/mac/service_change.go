@@ -0,0 +1,38 @@
+package mac
+
+import "fmt"
+
+// Actions of a service change
+const (
+	ActionNone    = "none"
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionRescale = "rescale"
+	ActionDefer   = "defer" // a stop waits for a deployment in progress
+)
+
+// ServiceChange is the outcome of executing the plan of one service.
+type ServiceChange struct {
+	Service
+	Action       string // ActionNone, ActionStart, ActionStop, ActionRescale or ActionDefer
+	TaskCount    int    // observed before the change
+	DesiredCount int    // wanted by the schedule
+	Applied      bool   // false for a plan (dry-run)
+	Err          error  // if the change failed
+	Note         string // why the action was taken this way, if not obvious
+	StopStrategy string // for ActionStop, how the service is stopped
+}
+
+func (c ServiceChange) String() string {
+	s := fmt.Sprintf("%s %s/%s (%d -> %d)", c.Action, c.ClusterName(), c.Name(), c.TaskCount, c.DesiredCount)
+	if c.StopStrategy != "" {
+		s += " using " + c.StopStrategy
+	}
+	if c.Note != "" {
+		s += ": " + c.Note
+	}
+	if c.Err != nil {
+		s += " failed: " + c.Err.Error()
+	}
+	return s
+}
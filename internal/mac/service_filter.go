@@ -0,0 +1,113 @@
+package mac
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// ServiceFilter selects the services shown on the status, schedule and service pages.
+// Names and tag values are globs or regular expressions as in ServiceSelector; the empty filter matches all services.
+type ServiceFilter struct {
+	Cluster string
+	Service string
+	Tags    map[string]string // value pattern by tag key
+}
+
+// ParseServiceFilter returns the filter of the query parameters cluster, service and tag, e.g. ?cluster=dev&tag=team:payments.
+// The tag parameter may be repeated; all given criteria must match.
+func ParseServiceFilter(query url.Values) (ServiceFilter, error) {
+	f := ServiceFilter{Cluster: query.Get("cluster"), Service: query.Get("service")}
+	for _, each := range query["tag"] {
+		if each == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(each, ":")
+		if !ok || key == "" {
+			return f, fmt.Errorf("invalid tag filter %q, expected key:value", each)
+		}
+		if f.Tags == nil {
+			f.Tags = map[string]string{}
+		}
+		f.Tags[key] = value
+	}
+	return f, ServiceSelector{Cluster: f.Cluster, Service: f.Service, Tags: f.Tags}.validatePatterns()
+}
+
+// IsEmpty returns true if the filter matches all services.
+func (f ServiceFilter) IsEmpty() bool {
+	return f.Cluster == "" && f.Service == "" && len(f.Tags) == 0
+}
+
+// Matches returns true if the service of the plan matches all criteria; tags are those of the ECS service.
+func (f ServiceFilter) Matches(plan *ServicePlan) bool {
+	if ok, _ := patternMatches(f.Cluster, plan.ClusterName()); !ok {
+		return false
+	}
+	if ok, _ := patternMatches(f.Service, plan.Name()); !ok {
+		return false
+	}
+	for k, v := range f.Tags {
+		value, ok := plan.Tags[k]
+		if !ok {
+			return false
+		}
+		if ok, _ := patternMatches(v, value); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Plans returns the plans of the services that match.
+func (f ServiceFilter) Plans(plans []*ServicePlan) []*ServicePlan {
+	list := []*ServicePlan{}
+	for _, each := range plans {
+		if f.Matches(each) {
+			list = append(list, each)
+		}
+	}
+	return list
+}
+
+// Query returns the query parameters of the filter, for links that show the same services.
+func (f ServiceFilter) Query() url.Values {
+	q := url.Values{}
+	if f.Cluster != "" {
+		q.Set("cluster", f.Cluster)
+	}
+	if f.Service != "" {
+		q.Set("service", f.Service)
+	}
+	keys := []string{}
+	for k := range f.Tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		q.Add("tag", k+":"+f.Tags[k])
+	}
+	return q
+}
+
+// TagFilter returns the tag filter parameter value of the first tag, for the filter form.
+func (f ServiceFilter) TagFilter() string {
+	if tags := f.Query()["tag"]; len(tags) > 0 {
+		return tags[0]
+	}
+	return ""
+}
+
+// serviceTags returns the tags of an ECS service by key.
+func serviceTags(service types.Service) map[string]string {
+	tags := map[string]string{}
+	for _, each := range service.Tags {
+		if each.Key != nil {
+			tags[*each.Key] = stringValue(each.Value)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,110 @@
+package mac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseServiceFilter(t *testing.T) {
+	f, err := ParseServiceFilter(url.Values{"cluster": {"dev"}, "tag": {"team:payments", "tier:/^web|api$/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Query().Encode(), "cluster=dev&tag=team%3Apayments&tag=tier%3A%2F%5Eweb%7Capi%24%2F"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	for _, each := range []string{"team", ":payments"} {
+		if _, err := ParseServiceFilter(url.Values{"tag": {each}}); err == nil {
+			t.Errorf("expected error for %q", each)
+		}
+	}
+	if _, err := ParseServiceFilter(url.Values{"service": {"/[/"}}); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
+
+func TestServiceFilterMatches(t *testing.T) {
+	plan := &ServicePlan{Service: Service{ARN: testServiceARN}, Tags: map[string]string{"team": "payments", "tier": "api"}}
+	for _, each := range []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{"cluster=dev", true},
+		{"cluster=prod", false},
+		{"service=a*", true},
+		{"tag=team:payments", true},
+		{"tag=team:pay*&tag=tier:api", true},
+		{"tag=team:search", false},
+		{"tag=owner:*", false},
+	} {
+		q, _ := url.ParseQuery(each.query)
+		f, _ := ParseServiceFilter(q)
+		if got := f.Matches(plan); got != each.want {
+			t.Errorf("%s: got %v want %v", each.query, got, each.want)
+		}
+	}
+}
+
+func TestControlsHandlerFilteredStatus(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, nil)
+	other := "arn:aws:ecs:eu-central-1:123456789012:service/prod/web"
+	fake.addService(other, 1, nil)
+	h := NewControlsHandler("test", func(r *http.Request) (*PlanExecutor, error) {
+		return NewPlanExecutor(fake, []*ServicePlan{
+			newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5."),
+			newTestPlan(t, other, "running=0 8 1-5. stopped=0 18 1-5."),
+		}), nil
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?cluster=prod", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "?do=service&amp;cluster=prod&amp;service=web") {
+		t.Errorf("missing link to service page in %s", body)
+	}
+	if strings.Contains(body, "service=api") {
+		t.Error("unexpected service of other cluster")
+	}
+	// bad filter
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?tag=team", nil))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestControlsHandlerServicePage(t *testing.T) {
+	h, _ := newTestControlsHandler(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?do=service&cluster=dev&service=api", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for _, each := range []string{"<h2>dev/api</h2>", "Next transition", "Stop service", "Snooze stop (60m)", "<h3>Today</h3>", "<svg"} {
+		if !strings.Contains(rec.Body.String(), each) {
+			t.Errorf("missing %q", each)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?do=service&cluster=prod&service=api", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestFetchedPlansHaveServiceTags(t *testing.T) {
+	fake := newFakeECS()
+	fake.addService(testServiceARN, 1, map[string]string{"moneypenny": "running=0 8 1-5. stopped=0 18 1-5.", "team": "payments"})
+	fetcher := NewPlanFetcher(fake)
+	if err := fetcher.FetchServicePlans(); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := ParseServiceFilter(url.Values{"tag": {"team:payments"}})
+	if got, want := f.Matches(fetcher.Plans[0]), true; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package mac
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Observed states of a service, next to Running and Stopped
+const (
+	Starting = "STARTING" // desired count is set but no task is running yet
+	Stopping = "STOPPING" // desired count is zero but tasks are still running
+)
+
+// Rollout states of a deployment
+const (
+	RolloutInProgress = string(types.DeploymentRolloutStateInProgress)
+	RolloutCompleted  = string(types.DeploymentRolloutStateCompleted)
+	RolloutFailed     = string(types.DeploymentRolloutStateFailed)
+)
+
+// ServiceInfo is the actual state of a service as described by ECS.
+type ServiceInfo struct {
+	Service
+	DesiredCount int
+	RunningCount int
+	PendingCount int
+	Deployments  int    // more than one if a rollout is in progress
+	RolloutState string // of the PRIMARY deployment, empty if unknown
+	Tags         map[string]string
+}
+
+// DescribeServiceInfo returns the counts, deployments and tags of the service.
+func DescribeServiceInfo(client ECSAPI, service Service) (ServiceInfo, error) {
+	svc, err := describeService(client, service)
+	if err != nil {
+		return ServiceInfo{Service: service}, err
+	}
+	return newServiceInfo(service, svc), nil
+}
+
+func newServiceInfo(service Service, svc types.Service) ServiceInfo {
+	info := ServiceInfo{
+		Service:      service,
+		DesiredCount: int(svc.DesiredCount),
+		RunningCount: int(svc.RunningCount),
+		PendingCount: int(svc.PendingCount),
+		Deployments:  len(svc.Deployments),
+		Tags:         map[string]string{},
+	}
+	for _, each := range svc.Deployments {
+		if aws.StringValue(each.Status) == "PRIMARY" {
+			info.RolloutState = string(each.RolloutState)
+		}
+	}
+	for _, each := range svc.Tags {
+		info.Tags[aws.StringValue(each.Key)] = aws.StringValue(each.Value)
+	}
+	return info
+}
+
+// State returns Running, Stopped, Starting or Stopping based on the counts.
+func (i ServiceInfo) State() string {
+	switch {
+	case i.DesiredCount > 0 && i.RunningCount > 0:
+		return Running
+	case i.DesiredCount > 0:
+		return Starting
+	case i.RunningCount > 0 || i.PendingCount > 0:
+		return Stopping
+	}
+	return Stopped
+}
+
+// IsRollingOut returns true if a deployment is in progress.
+func (i ServiceInfo) IsRollingOut() bool {
+	return i.RolloutState == RolloutInProgress || i.Deployments > 1
+}
+
+// decideAction returns what to do to bring the service in the state of the scheduled event, and why.
+//
+//	scheduled | desired count     | action
+//	stopped   | > 0               | stop
+//	stopped   | 0                 | none
+//	running   | 0                 | start
+//	running   | not the scheduled | rescale
+//	running   | the scheduled     | none
+func decideAction(info ServiceInfo, event ScheduledEvent) (string, string) {
+	if event.DesiredState != Running {
+		if info.DesiredCount > 0 {
+			return ActionStop, "service is running but must be stopped"
+		}
+		if info.RunningCount > 0 {
+			return ActionNone, "service is stopping"
+		}
+		return ActionNone, "service is in expected state"
+	}
+	if info.DesiredCount == 0 {
+		return ActionStart, "service must be running"
+	}
+	if info.DesiredCount != event.DesiredCount {
+		return ActionRescale, "service must have different task count"
+	}
+	if info.RunningCount < info.DesiredCount && info.PendingCount == 0 {
+		return ActionNone, "service has fewer running tasks than desired and none pending"
+	}
+	return ActionNone, "service is in expected state"
+}
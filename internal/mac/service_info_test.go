@@ -0,0 +1,102 @@
+package mac
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestServiceInfoState(t *testing.T) {
+	for _, each := range []struct {
+		desired, running, pending int
+		want                      string
+	}{
+		{0, 0, 0, Stopped},
+		{2, 2, 0, Running},
+		{2, 0, 2, Starting},
+		{2, 0, 0, Starting}, // crash looping
+		{0, 1, 0, Stopping},
+	} {
+		info := ServiceInfo{DesiredCount: each.desired, RunningCount: each.running, PendingCount: each.pending}
+		if got := info.State(); got != each.want {
+			t.Errorf("%v: got %v want %v", each, got, each.want)
+		}
+	}
+}
+
+func TestDecideAction(t *testing.T) {
+	run2 := ScheduledEvent{DesiredState: Running, DesiredCount: 2}
+	stop := ScheduledEvent{DesiredState: Stopped}
+	for _, each := range []struct {
+		event                     ScheduledEvent
+		desired, running, pending int
+		want                      string
+	}{
+		{stop, 2, 2, 0, ActionStop},
+		{stop, 2, 0, 0, ActionStop}, // crash looping, still desired
+		{stop, 0, 1, 0, ActionNone}, // draining
+		{stop, 0, 0, 0, ActionNone},
+		{run2, 0, 0, 0, ActionStart},
+		{run2, 0, 1, 0, ActionStart}, // still draining
+		{run2, 1, 1, 0, ActionRescale},
+		{run2, 2, 0, 0, ActionNone}, // crash looping, not restarted
+		{run2, 2, 1, 1, ActionNone},
+	} {
+		info := ServiceInfo{DesiredCount: each.desired, RunningCount: each.running, PendingCount: each.pending}
+		if got, _ := decideAction(info, each.event); got != each.want {
+			t.Errorf("%v: got %v want %v", each, got, each.want)
+		}
+	}
+}
+
+func TestDescribeServiceInfo(t *testing.T) {
+	fake := newFakeECS()
+	s := fake.addService(testServiceARN, 1, map[string]string{"env": "dev"})
+	s.pending = 1
+	s.deployments = []types.Deployment{
+		{Status: aws.String("PRIMARY"), RolloutState: types.DeploymentRolloutStateInProgress},
+		{Status: aws.String("ACTIVE"), RolloutState: types.DeploymentRolloutStateCompleted},
+	}
+	info, err := DescribeServiceInfo(fake, Service{ARN: testServiceARN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DesiredCount != 1 || info.RunningCount != 1 || info.PendingCount != 1 || info.Deployments != 2 {
+		t.Errorf("unexpected counts %+v", info)
+	}
+	if !info.IsRollingOut() {
+		t.Error("expected rollout in progress")
+	}
+	if got, want := info.Tags["env"], "dev"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorCrashLoopingIsNotStarted(t *testing.T) {
+	fake := newFakeECS()
+	s := fake.addService(testServiceARN, 0, nil)
+	s.desired = 1
+	ex := NewPlanExecutor(fake, []*ServicePlan{newTestPlan(t, testServiceARN, "running=0 0 0-6.")})
+	ex.Apply()
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+}
+
+func TestStatusWriterCounts(t *testing.T) {
+	fake := newFakeECS()
+	s := fake.addService(testServiceARN, 1, nil)
+	s.desired, s.pending = 3, 2
+	w := StatusWriter{client: fake}
+	b := new(strings.Builder)
+	if err := w.WriteOn([]*ServicePlan{newTestPlan(t, testServiceARN, "running=0 0 0-6. count=3.")}, b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<td class="count">3</td>
+        <td class="count">1</td>
+        <td class="count">2</td>`) {
+		t.Errorf("missing desired, running and pending counts in %s", b.String())
+	}
+}
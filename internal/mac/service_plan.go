@@ -1,17 +1,44 @@
 package mac
 
 import (
+	"fmt"
 	"slices"
+	"strings"
 	"time"
 )
 
+// Sources of a service plan
+const (
+	SourceTag  = "tag"  // moneypenny tag of the ECS service
+	SourceFile = "file" // local service plans file
+)
+
 type ServicePlan struct {
-	Service
-	TagValue         string         `json:"moneypenny"`
-	ResolvedTagValue string         // if TagValue is a reference to another service then this value is the actual tag value with state changes
-	StateChanges     []*StateChange `json:"state-changes"` // sorted by time on day
-	Disabled         bool           `json:"disabled"`
-	TagError         string         `json:"-"`
+	Service          `yaml:",inline"`
+	TagValue         string         `json:"moneypenny" yaml:"moneypenny"`
+	ResolvedTagValue string         `json:"-" yaml:"-"`                         // if TagValue is a reference to another service then this value is the actual tag value with state changes
+	StateChanges     []*StateChange `json:"state-changes" yaml:"state-changes"` // sorted by time on day
+	Disabled         bool           `json:"disabled" yaml:"disabled"`
+	WarnMinutes      int            `json:"warn,omitempty" yaml:"warn,omitempty"` // if set, a warning is sent this many minutes before a scheduled stop
+	Stop             string         `json:"stop,omitempty" yaml:"stop,omitempty"` // stop strategy, e.g. graceful or drain 10
+	stopStrategy     StopStrategy
+	TagError         string            `json:"-" yaml:"-"`
+	Source           string            `json:"-" yaml:"-"`                               // where this plan was defined, SourceTag or SourceFile
+	Selector         *ServiceSelector  `json:"select,omitempty" yaml:"select,omitempty"` // instead of service-arn, expanded by ExpandServicePlans
+	MatchedBy        string            `json:"-" yaml:"-"`                               // the selector that matched the service, if any
+	Tags             map[string]string `json:"-" yaml:"-"`                               // of the ECS service, if fetched
+}
+
+// clone returns a copy of the plan with its own state changes, such that changing one does not change the other.
+func (t *ServicePlan) clone() *ServicePlan {
+	c := *t
+	c.StateChanges = make([]*StateChange, len(t.StateChanges))
+	for i, each := range t.StateChanges {
+		change := *each
+		change.CronSpec.DaysOfWeek = slices.Clone(each.CronSpec.DaysOfWeek)
+		c.StateChanges[i] = &change
+	}
+	return &c
 }
 
 // the actual tag value with state changes
@@ -86,28 +113,82 @@ end:
 func (t *ServicePlan) Validate() error {
 	changes := t.tagValue()
 	if changes == "" {
-		return nil
+		// this exists when reading from file
+		return t.validateStateChanges()
 	}
-	chgs, err := ParseStateChanges(changes)
+	chgs, opts, err := parseTagValue(changes)
 	if err != nil {
 		t.TagError = "BAD SYNTAX: " + changes
 		t.Disabled = true
 		return err
 	}
-	slices.SortFunc(chgs, func(a, b *StateChange) int {
-		return intCompare(a.CronSpec.Hour+a.CronSpec.Minute*60, b.CronSpec.Hour+b.CronSpec.Minute*60)
-	})
 	t.StateChanges = chgs
+	if opts.warnMinutes > 0 {
+		t.WarnMinutes = opts.warnMinutes
+	}
+	if opts.stop != nil {
+		t.Stop = opts.stop.String()
+	}
+	t.sortStateChanges()
+	return t.validateStop()
+}
+
+// validateStop parses the stop strategy, if any.
+func (t *ServicePlan) validateStop() error {
+	if t.Stop == "" {
+		return nil
+	}
+	strategy, err := ParseStopStrategy(t.Stop)
+	if err != nil {
+		return fmt.Errorf("invalid stop %q: %w", t.Stop, err)
+	}
+	t.stopStrategy = strategy
 	return nil
-	// this exists when reading from file
-	// for _, each := range t.StateChanges {
-	// 	spec, err := ParseCronSpec(each.Cron)
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	each.CronSpec = spec
-	// }
-	// return nil
+}
+
+// validateStateChanges checks and completes the state changes that were given structurally.
+func (t *ServicePlan) validateStateChanges() error {
+	if t.WarnMinutes < 0 || t.WarnMinutes > minutesPerDay {
+		return fmt.Errorf("warn must be between 1 and %d minutes, got %d", minutesPerDay, t.WarnMinutes)
+	}
+	if err := t.validateStop(); err != nil {
+		return err
+	}
+	for i, each := range t.StateChanges {
+		if each == nil {
+			return fmt.Errorf("state-changes[%d]: missing state change", i)
+		}
+		switch strings.ToUpper(each.DesiredState) {
+		case Running:
+			each.DesiredState = Running
+			if each.DesiredCount == 0 { // unspecified
+				each.DesiredCount = 1
+			}
+		case Stopped:
+			each.DesiredState = Stopped
+			if each.DesiredCount != 0 {
+				return fmt.Errorf("state-changes[%d]: desired-count must be 0 for stopped, got %d", i, each.DesiredCount)
+			}
+		default:
+			return fmt.Errorf("state-changes[%d]: desired-state must be running or stopped, got %q", i, each.DesiredState)
+		}
+		if each.DesiredCount < 0 {
+			return fmt.Errorf("state-changes[%d]: desired-count cannot be negative, got %d", i, each.DesiredCount)
+		}
+		spec, err := ParseCronSpec(each.Cron)
+		if err != nil {
+			return fmt.Errorf("state-changes[%d]: invalid cron %q: %w", i, each.Cron, err)
+		}
+		each.CronSpec = spec
+	}
+	t.sortStateChanges()
+	return nil
+}
+
+func (t *ServicePlan) sortStateChanges() {
+	slices.SortFunc(t.StateChanges, func(a, b *StateChange) int {
+		return intCompare(a.CronSpec.Hour*60+a.CronSpec.Minute, b.CronSpec.Hour*60+b.CronSpec.Minute)
+	})
 }
 
 func (t *ServicePlan) DesiredCountAt(when time.Time) int {
@@ -127,6 +208,22 @@ func (t *ServicePlan) DesiredCountAt(when time.Time) int {
 	return desired
 }
 
+// StopStrategy returns how the service is stopped, DefaultStopStrategy if not specified.
+func (t *ServicePlan) StopStrategy() StopStrategy {
+	if t.stopStrategy.Mode == "" {
+		return DefaultStopStrategy
+	}
+	return t.stopStrategy
+}
+
+// SourceLabel returns where this plan was defined, including the selector that matched the service.
+func (t *ServicePlan) SourceLabel() string {
+	if t.MatchedBy != "" {
+		return t.Source + " (" + t.MatchedBy + ")"
+	}
+	return t.Source
+}
+
 func (t *ServicePlan) CronLabel() string {
 	if t.TagError != "" {
 		return t.TagError
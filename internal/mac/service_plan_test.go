@@ -78,3 +78,13 @@ func TestServicePlanOfficeTime(t *testing.T) {
 		t.Errorf("Expected %d, got %d", want, p)
 	}
 }
+func TestServicePlanSortsByHourThenMinute(t *testing.T) {
+	sp := new(ServicePlan)
+	sp.TagValue = "stopped=0 9 1-5. running=30 8 1-5."
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sp.StateChanges[0].Cron, "30 8 1-5"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
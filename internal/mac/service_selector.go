@@ -0,0 +1,187 @@
+package mac
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// ServiceSelector matches ECS services by cluster name, service name and tags.
+// Names and tag values are globs (e.g. feature-*) or regular expressions when enclosed in slashes (e.g. /^feature-\d+$/).
+// Empty criteria match all services; all given criteria must match.
+type ServiceSelector struct {
+	Cluster string            `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Service string            `json:"service,omitempty" yaml:"service,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+func (s ServiceSelector) String() string {
+	parts := []string{}
+	if s.Cluster != "" {
+		parts = append(parts, "cluster="+s.Cluster)
+	}
+	if s.Service != "" {
+		parts = append(parts, "service="+s.Service)
+	}
+	keys := []string{}
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("tag:%s=%s", k, s.Tags[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Validate checks that all patterns are valid and at least one criterium is given.
+func (s ServiceSelector) Validate() error {
+	if s.Cluster == "" && s.Service == "" && len(s.Tags) == 0 {
+		return errors.New("select must have a cluster, service or tags")
+	}
+	return s.validatePatterns()
+}
+
+// validatePatterns checks that all patterns are valid.
+func (s ServiceSelector) validatePatterns() error {
+	if _, err := patternMatches(s.Cluster, ""); err != nil {
+		return fmt.Errorf("invalid cluster pattern %q: %w", s.Cluster, err)
+	}
+	if _, err := patternMatches(s.Service, ""); err != nil {
+		return fmt.Errorf("invalid service pattern %q: %w", s.Service, err)
+	}
+	for k, v := range s.Tags {
+		if _, err := patternMatches(v, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q for tag %s: %w", v, k, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if all criteria match the service.
+func (s ServiceSelector) Matches(service types.Service) bool {
+	svc := Service{ARN: stringValue(service.ServiceArn)}
+	if ok, _ := patternMatches(s.Cluster, svc.ClusterName()); s.Cluster != "" && !ok {
+		return false
+	}
+	if ok, _ := patternMatches(s.Service, svc.Name()); s.Service != "" && !ok {
+		return false
+	}
+	for k, v := range s.Tags {
+		if !hasTag(service, k) {
+			return false
+		}
+		if ok, _ := patternMatches(v, TagValue(service, k)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity is used to choose between selectors that match the same service.
+// Literal names weigh more than patterns; each tag adds weight.
+func (s ServiceSelector) specificity() (weight int) {
+	for _, each := range []string{s.Cluster, s.Service} {
+		if each == "" {
+			continue
+		}
+		if isLiteralPattern(each) {
+			weight += 3
+		} else {
+			weight += 1
+		}
+	}
+	return weight + 2*len(s.Tags)
+}
+
+func isLiteralPattern(pattern string) bool {
+	return !isRegexPattern(pattern) && !strings.ContainsAny(pattern, `*?[\`)
+}
+
+func isRegexPattern(pattern string) bool {
+	return len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// patternMatches matches a glob or /regex/ pattern; the empty pattern matches everything.
+func patternMatches(pattern, value string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	if isRegexPattern(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(value), nil
+	}
+	return path.Match(pattern, value)
+}
+
+func hasTag(service types.Service, tagKey string) bool {
+	for _, each := range service.Tags {
+		if each.Key != nil && *each.Key == tagKey {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// ExpandServicePlans replaces the plans with a selector by a plan for each matching service.
+// Precedence per service:
+//   - a plan with a service-arn wins over plans with a selector
+//   - the most specific selector wins, see specificity
+//   - otherwise the first selector in the plans wins
+func ExpandServicePlans(plans []*ServicePlan, services []types.Service) (expanded []*ServicePlan) {
+	byARN := map[string]bool{}
+	selectors := []*ServicePlan{}
+	for _, each := range plans {
+		if each.Selector == nil {
+			byARN[each.ARN] = true
+			expanded = append(expanded, each)
+			continue
+		}
+		selectors = append(selectors, each)
+	}
+	if len(selectors) == 0 {
+		return
+	}
+	for _, svc := range services {
+		arn := stringValue(svc.ServiceArn)
+		if byARN[arn] {
+			continue
+		}
+		var best *ServicePlan
+		for _, each := range selectors {
+			if !each.Selector.Matches(svc) {
+				continue
+			}
+			if best == nil || each.Selector.specificity() > best.Selector.specificity() {
+				best = each
+			}
+		}
+		if best == nil {
+			continue
+		}
+		sp := best.clone()
+		sp.ARN = arn
+		sp.Selector = nil
+		sp.MatchedBy = best.Selector.String()
+		sp.Tags = serviceTags(svc)
+		slog.Debug("service matched by selector", "service", arn, "select", sp.MatchedBy)
+		expanded = append(expanded, sp)
+	}
+	return
+}
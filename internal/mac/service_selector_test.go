@@ -0,0 +1,115 @@
+package mac
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func ecsService(cluster, name string, tags ...string) types.Service {
+	s := types.Service{ServiceArn: aws.String("arn:aws:ecs:eu-central-1:9111111:service/" + cluster + "/" + name)}
+	for i := 0; i < len(tags); i += 2 {
+		s.Tags = append(s.Tags, types.Tag{Key: aws.String(tags[i]), Value: aws.String(tags[i+1])})
+	}
+	return s
+}
+
+func TestServiceSelectorMatches(t *testing.T) {
+	svc := ecsService("dev", "feature-123", "env", "dev", "team", "payments")
+	for _, each := range []struct {
+		sel  ServiceSelector
+		want bool
+	}{
+		{ServiceSelector{Cluster: "dev"}, true},
+		{ServiceSelector{Cluster: "dev-*"}, false},
+		{ServiceSelector{Cluster: "d*", Service: "feature-*"}, true},
+		{ServiceSelector{Service: `/^feature-\d+$/`}, true},
+		{ServiceSelector{Service: `/^feature-[a-z]+$/`}, false},
+		{ServiceSelector{Tags: map[string]string{"env": "dev"}}, true},
+		{ServiceSelector{Tags: map[string]string{"env": "prod"}}, false},
+		{ServiceSelector{Tags: map[string]string{"owner": "*"}}, false},
+		{ServiceSelector{Cluster: "dev", Tags: map[string]string{"team": "pay*"}}, true},
+	} {
+		if got := each.sel.Matches(svc); got != each.want {
+			t.Errorf("%s: got %v want %v", each.sel, got, each.want)
+		}
+	}
+}
+
+func TestServiceSelectorValidate(t *testing.T) {
+	if err := (ServiceSelector{}).Validate(); err == nil {
+		t.Error("error expected for empty selector")
+	}
+	if err := (ServiceSelector{Service: "/feature-(/"}).Validate(); err == nil {
+		t.Error("error expected for bad regex")
+	}
+	if err := (ServiceSelector{Cluster: "dev-["}).Validate(); err == nil {
+		t.Error("error expected for bad glob")
+	}
+}
+
+func TestExpandServicePlans(t *testing.T) {
+	services := []types.Service{
+		ecsService("dev", "api"),
+		ecsService("dev", "feature-1", "env", "dev"),
+		ecsService("dev", "feature-2"),
+		ecsService("prod", "api", "env", "dev"),
+	}
+	plans := []*ServicePlan{
+		{Selector: &ServiceSelector{Cluster: "dev"}, TagValue: "stopped=0 0 0-6.", Source: SourceFile},
+		{Selector: &ServiceSelector{Cluster: "dev", Service: "feature-*"}, TagValue: "running=0 8 1-5.", Source: SourceFile},
+		{Selector: &ServiceSelector{Tags: map[string]string{"env": "dev"}}, TagValue: "running=0 9 1-5.", Source: SourceFile},
+		{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}, TagValue: "running=0 7 1-5.", Source: SourceFile},
+	}
+	expanded := ExpandServicePlans(plans, services)
+	want := map[string]string{
+		"arn:aws:ecs:eu-central-1:9111111:service/dev/api":       "",
+		"arn:aws:ecs:eu-central-1:9111111:service/dev/feature-1": "cluster=dev,service=feature-*",
+		"arn:aws:ecs:eu-central-1:9111111:service/dev/feature-2": "cluster=dev,service=feature-*",
+		"arn:aws:ecs:eu-central-1:9111111:service/prod/api":      "tag:env=dev",
+	}
+	if got, want := len(expanded), len(want); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for _, each := range expanded {
+		if got, want := each.MatchedBy, want[each.ARN]; got != want {
+			t.Errorf("%s: got %q want %q", each.ARN, got, want)
+		}
+		if each.Selector != nil {
+			t.Errorf("%s: selector not expanded", each.ARN)
+		}
+	}
+}
+
+func TestExpandServicePlansFirstWinsOnTie(t *testing.T) {
+	services := []types.Service{ecsService("dev", "api")}
+	plans := []*ServicePlan{
+		{Selector: &ServiceSelector{Cluster: "dev"}, TagValue: "first"},
+		{Selector: &ServiceSelector{Service: "api"}, TagValue: "second"},
+	}
+	expanded := ExpandServicePlans(plans, services)
+	if got, want := expanded[0].TagValue, "first"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExpandServicePlansOwnStateChanges(t *testing.T) {
+	services := []types.Service{ecsService("dev", "api"), ecsService("dev", "web")}
+	plan := &ServicePlan{Selector: &ServiceSelector{Cluster: "dev"}, TagValue: "running=0 8 1-5. stopped=0 18 1-5."}
+	if err := plan.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	expanded := ExpandServicePlans([]*ServicePlan{plan}, services)
+	expanded[0].StateChanges[0].DesiredCount = 3
+	expanded[0].StateChanges[0].CronSpec.DaysOfWeek[0] = time.Sunday
+	for _, each := range []*ServicePlan{plan, expanded[1]} {
+		if got, want := each.StateChanges[0].DesiredCount, 1; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+		if got, want := each.StateChanges[0].CronSpec.DaysOfWeek[0], time.Monday; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	}
+}
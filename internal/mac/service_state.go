@@ -0,0 +1,52 @@
+package mac
+
+import (
+	"context"
+	"time"
+)
+
+// Keys of the state that moneypenny keeps for an ECS service, across invocations.
+const (
+	warnedStateKey  = "moneypenny-warned"  // time of the stop for which the last warning was sent
+	snoozedStateKey = "moneypenny-snoozed" // scheduled stops are skipped until this time
+)
+
+// ServiceState reads and writes the state of a service in a StateStore.
+type ServiceState struct {
+	store StateStore
+}
+
+func NewServiceState(store StateStore) *ServiceState {
+	return &ServiceState{store: store}
+}
+
+// Get returns the value of the state key or empty if absent.
+func (s *ServiceState) Get(service Service, key string) (string, error) {
+	return s.store.Get(context.Background(), service.ARN, key)
+}
+
+func (s *ServiceState) Set(service Service, key, value string) error {
+	return s.store.Set(context.Background(), service.ARN, key, value)
+}
+
+// Clear removes the state key, if present.
+func (s *ServiceState) Clear(service Service, key string) error {
+	return s.store.Delete(context.Background(), service.ARN, key)
+}
+
+// GetTime returns the time stored in the state key or zero if absent or invalid.
+func (s *ServiceState) GetTime(service Service, key string) (time.Time, error) {
+	v, err := s.Get(service, key)
+	if err != nil || v == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return t, nil
+}
+
+func (s *ServiceState) SetTime(service Service, key string, t time.Time) error {
+	return s.Set(service, key, t.UTC().Format(time.RFC3339))
+}
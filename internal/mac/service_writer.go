@@ -0,0 +1,99 @@
+package mac
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/tre"
+)
+
+//go:embed assets/service.html
+var serviceHTML string
+
+//go:embed assets/filter.html
+var filterHTML string
+
+var filterTemplate = template.Must(template.New("filter").Parse(filterHTML))
+
+// ServiceWriter writes the page of one service: its state, next transition, today's state changes and actions.
+type ServiceWriter struct {
+	client    ECSAPI
+	weekPlan  *WeekPlan
+	drift     string // empty if the service is as scheduled
+	csrfToken string // for posting actions
+}
+
+type servicePageData struct {
+	ClusterName, ServiceName string
+	RowClass                 string
+	Info                     ServiceInfo
+	Next                     *ScheduledEvent
+	Today                    []ScheduledEvent
+	Cron, Stop, Source       string
+	Drift                    string
+	Links                    []LinkData
+	TagsURL                  template.URL
+	ShareURL                 template.URL
+	CSRFToken                string
+}
+
+func (r ServiceWriter) WriteOn(plan *ServicePlan, now time.Time, w io.Writer) error {
+	tmpl, err := template.New("service").Parse(serviceHTML)
+	if err != nil {
+		return tre.New(err, "parse template fail")
+	}
+	now = now.In(userLocation)
+	info, err := DescribeServiceInfo(r.client, plan.Service)
+	if err != nil {
+		slog.Warn("failed to describe service", "name", plan.Name(), "err", err)
+	}
+	data := servicePageData{
+		ClusterName: plan.ClusterName(),
+		ServiceName: plan.Name(),
+		RowClass:    "running",
+		Info:        info,
+		Cron:        plan.CronLabel(),
+		Stop:        plan.StopStrategy().String(),
+		Source:      plan.SourceLabel(),
+		Drift:       r.drift,
+		TagsURL:     template.URL(plan.TagsURL()),
+		ShareURL:    servicePageURL(plan.Service),
+		CSRFToken:   r.csrfToken,
+	}
+	if info.DesiredCount == 0 {
+		data.RowClass = "stopped"
+	}
+	if plan.Disabled {
+		data.RowClass = "disabled"
+	}
+	if event, ok := r.weekPlan.NextEventAfter(plan.Service, now); ok {
+		data.Next = &event
+	}
+	for _, each := range r.weekPlan.ScheduledEventsOn(now) {
+		if each.ARN == plan.ARN {
+			data.Today = append(data.Today, each)
+		}
+	}
+	if info.DesiredCount == 0 {
+		data.Links = append(data.Links, LinkData{Fields: map[string]string{"do": "start", "service-arn": plan.ARN}, Title: "Start service"})
+	} else {
+		data.Links = append(data.Links,
+			LinkData{Fields: map[string]string{"do": "stop", "service-arn": plan.ARN}, Title: "Stop service"},
+			LinkData{Fields: map[string]string{"do": "snooze", "service-arn": plan.ARN, "minutes": strconv.Itoa(SnoozeMinutes)},
+				Title: "Snooze stop (" + strconv.Itoa(SnoozeMinutes) + "m)"})
+	}
+	return tre.New(tmpl.Execute(w, data), "template exec fail")
+}
+
+// servicePageURL returns the relative URL of the page of the service, stable across deployments.
+func servicePageURL(service Service) template.URL {
+	q := url.Values{}
+	q.Set("cluster", service.ClusterName())
+	q.Set("service", service.Name())
+	return template.URL("?do=service&" + q.Encode())
+}
@@ -1,18 +1,15 @@
 package mac
 
 import (
-	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
-	"strings"
 )
 
 type StateChange struct {
-	DesiredState string `json:"desired-state"`
-	DesiredCount int    `json:"desired-count"`
-	Cron         string `json:"cron"`
-	CronSpec     CronSpec
+	DesiredState string   `json:"desired-state" yaml:"desired-state"`
+	DesiredCount int      `json:"desired-count" yaml:"desired-count"`
+	Cron         string   `json:"cron" yaml:"cron"`
+	CronSpec     CronSpec `json:"-" yaml:"-"`
 }
 
 func (s *StateChange) String() string {
@@ -20,66 +17,45 @@ func (s *StateChange) String() string {
 }
 
 // running=0 8 1-5. stopped=0 18 1-5. count=2.
+// Errors are *TagSyntaxError with the column in the input.
 func ParseStateChanges(input string) (list []*StateChange, err error) {
-	changeParts := strings.Split(strings.TrimSpace(input), ".")
-	for _, each := range changeParts {
-		if len(each) == 0 {
-			continue
-		}
-		stateParts := strings.Split(strings.TrimSpace(each), "=")
-		if len(stateParts) != 2 {
-			return list, fmt.Errorf("expected: state=expression. parts:%v", stateParts)
-		}
-		stateName := stateParts[0]
-		if strings.HasPrefix(stateName, "//") {
-			break
-		}
-		switch stateName {
-		case "running":
-			expr := strings.Trim(stateParts[1], ".")
-			spec, err := ParseCronSpec(expr)
-			if err != nil {
-				return list, fmt.Errorf("invalid spec for running:%w, expression:%q", err, expr)
-			}
-			list = append(list, &StateChange{
-				DesiredState: Running,
-				DesiredCount: 1,
-				Cron:         expr,
-				CronSpec:     spec,
-			})
-		case "stopped":
-			expr := strings.Trim(stateParts[1], ".")
-			spec, err := ParseCronSpec(expr)
-			if err != nil {
-				return list, fmt.Errorf("invalid spec for stopped:%w, expression:%q", err, expr)
-			}
-			list = append(list, &StateChange{
-				DesiredState: Stopped,
-				DesiredCount: 0,
-				Cron:         expr,
-				CronSpec:     spec,
-			})
+	list, _, err = parseTagValue(input)
+	return
+}
+
+// tagOptions are the statements of a tag value that are not state changes.
+type tagOptions struct {
+	warnMinutes int           // warn=15.
+	stop        *StopStrategy // stop=graceful.
+}
+
+func parseTagValue(input string) (list []*StateChange, opts tagOptions, err error) {
+	stmts, err := parseTag(input)
+	if err != nil {
+		return list, opts, err
+	}
+	for _, each := range stmts {
+		switch each.name {
+		case "running", "stopped":
+			list = append(list, each.change)
 		case "count":
-			expr := strings.Trim(stateParts[1], ".")
-			c, err := strconv.Atoi(expr)
-			if err != nil {
-				return list, fmt.Errorf("invalid spec for count:%w, expression:%q", err, expr)
-			}
 			// find running change to update its count
 			var run *StateChange
-			for _, each := range list {
-				if each.DesiredState == Running {
-					run = each
+			for _, other := range list {
+				if other.DesiredState == Running {
+					run = other
 					break
 				}
 			}
 			if run == nil {
 				slog.Warn("no running change specified for count", "moneypenny", input)
 			} else {
-				run.DesiredCount = c
+				run.DesiredCount = each.value
 			}
-		default:
-			return list, errors.New("unknown state:" + stateParts[0])
+		case "warn":
+			opts.warnMinutes = each.value
+		case "stop":
+			opts.stop = &each.stop
 		}
 	}
 	return
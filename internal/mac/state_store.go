@@ -0,0 +1,194 @@
+package mac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// StateStore keeps values by service ARN and key across runs, e.g. the end of a snooze.
+// The state is not kept in the tags of the service because these are usually owned by infrastructure code, such as Terraform.
+type StateStore interface {
+	// Get returns the value or empty if absent.
+	Get(ctx context.Context, serviceARN, key string) (string, error)
+	Set(ctx context.Context, serviceARN, key, value string) error
+	Delete(ctx context.Context, serviceARN, key string) error
+}
+
+// NewStateStore returns the store for the spec:
+//   - path/to/state.json or file://path/to/state.json
+//   - dynamodb://table, which can be the table of the audit
+func NewStateStore(spec string) (StateStore, error) {
+	switch {
+	case strings.HasPrefix(spec, dynamoDBAuditPrefix):
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return NewDynamoDBStateStore(dynamodb.NewFromConfig(cfg), strings.TrimPrefix(spec, dynamoDBAuditPrefix)), nil
+	case spec == "":
+		return nil, errors.New("no state store given")
+	default:
+		return NewFileStateStore(strings.TrimPrefix(spec, fileSourcePrefix)), nil
+	}
+}
+
+// MemoryStateStore keeps the state for the lifetime of the process only.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	values map[string]map[string]string // by service ARN and key
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{values: map[string]map[string]string{}}
+}
+
+func (s *MemoryStateStore) Get(ctx context.Context, serviceARN, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[serviceARN][key], nil
+}
+
+func (s *MemoryStateStore) Set(ctx context.Context, serviceARN, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[serviceARN] == nil {
+		s.values[serviceARN] = map[string]string{}
+	}
+	s.values[serviceARN][key] = value
+	return nil
+}
+
+func (s *MemoryStateStore) Delete(ctx context.Context, serviceARN, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values[serviceARN], key)
+	if len(s.values[serviceARN]) == 0 {
+		delete(s.values, serviceARN)
+	}
+	return nil
+}
+
+// FileStateStore keeps the state as a JSON object by service ARN and key in a local file.
+type FileStateStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+func (s *FileStateStore) Get(ctx context.Context, serviceARN, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, err := s.read()
+	return values[serviceARN][key], err
+}
+
+func (s *FileStateStore) Set(ctx context.Context, serviceARN, key, value string) error {
+	return s.update(func(values map[string]map[string]string) {
+		if values[serviceARN] == nil {
+			values[serviceARN] = map[string]string{}
+		}
+		values[serviceARN][key] = value
+	})
+}
+
+func (s *FileStateStore) Delete(ctx context.Context, serviceARN, key string) error {
+	return s.update(func(values map[string]map[string]string) {
+		delete(values[serviceARN], key)
+		if len(values[serviceARN]) == 0 {
+			delete(values, serviceARN)
+		}
+	})
+}
+
+func (s *FileStateStore) update(change func(map[string]map[string]string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, err := s.read()
+	if err != nil {
+		return err
+	}
+	change(values)
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+func (s *FileStateStore) read() (map[string]map[string]string, error) {
+	values := map[string]map[string]string{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return values, err
+	}
+	return values, json.Unmarshal(data, &values)
+}
+
+// dynamoDBStateAPI is the part of the DynamoDB client used for the state.
+type dynamoDBStateAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// dynamoDBStatePartition is the value of the partition key of all state items; the sort key is the service ARN and key.
+const dynamoDBStatePartition = "moneypenny-state"
+
+// DynamoDBStateStore keeps the state in a table with partition key "pk" (S) and sort key "sk" (S), the same as the DynamoDBAuditStore.
+type DynamoDBStateStore struct {
+	client dynamoDBStateAPI
+	table  string
+}
+
+func NewDynamoDBStateStore(client dynamoDBStateAPI, table string) *DynamoDBStateStore {
+	return &DynamoDBStateStore{client: client, table: table}
+}
+
+func (s *DynamoDBStateStore) key(serviceARN, key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: dynamoDBStatePartition},
+		"sk": &types.AttributeValueMemberS{Value: serviceARN + "#" + key},
+	}
+}
+
+func (s *DynamoDBStateStore) Get(ctx context.Context, serviceARN, key string) (string, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            s.key(serviceARN, key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if v, ok := out.Item["value"].(*types.AttributeValueMemberS); ok {
+		return v.Value, nil
+	}
+	return "", nil
+}
+
+func (s *DynamoDBStateStore) Set(ctx context.Context, serviceARN, key, value string) error {
+	item := s.key(serviceARN, key)
+	item["value"] = &types.AttributeValueMemberS{Value: value}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	return err
+}
+
+func (s *DynamoDBStateStore) Delete(ctx context.Context, serviceARN, key string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(s.table), Key: s.key(serviceARN, key)})
+	return err
+}
@@ -0,0 +1,100 @@
+package mac
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// index returns the position of the item with the same keys, -1 if absent.
+func (l *localDynamoDB) index(key map[string]types.AttributeValue) int {
+	value := func(item map[string]types.AttributeValue, name string) string {
+		return item[name].(*types.AttributeValueMemberS).Value
+	}
+	return slices.IndexFunc(l.items, func(each map[string]types.AttributeValue) bool {
+		return value(each, "pk") == value(key, "pk") && value(each, "sk") == value(key, "sk")
+	})
+}
+
+func (l *localDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := &dynamodb.GetItemOutput{}
+	if i := l.index(params.Key); i != -1 {
+		out.Item = l.items[i]
+	}
+	return out, nil
+}
+
+func (l *localDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := l.index(params.Key); i != -1 {
+		l.items = slices.Delete(l.items, i, i+1)
+	}
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestStateStores(t *testing.T) {
+	table := new(localDynamoDB)
+	for name, store := range map[string]StateStore{
+		"memory":   NewMemoryStateStore(),
+		"file":     NewFileStateStore(filepath.Join(t.TempDir(), "state.json")),
+		"dynamodb": NewDynamoDBStateStore(table, "audit"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if got, err := store.Get(ctx, testServiceARN, snoozedStateKey); err != nil || got != "" {
+				t.Fatalf("got %v %v want empty", got, err)
+			}
+			store.Set(ctx, testServiceARN, snoozedStateKey, "a")
+			store.Set(ctx, testServiceARN, snoozedStateKey, "b")
+			store.Set(ctx, testServiceARN, warnedStateKey, "c")
+			if got, want := mustGet(t, store, snoozedStateKey), "b"; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+			if err := store.Delete(ctx, testServiceARN, snoozedStateKey); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := mustGet(t, store, snoozedStateKey), ""; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+			if got, want := mustGet(t, store, warnedStateKey), "c"; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+		})
+	}
+	if got, want := len(table.items), 1; got != want {
+		t.Errorf("got %v items want %v", got, want)
+	}
+}
+
+func mustGet(t *testing.T, store StateStore, key string) string {
+	t.Helper()
+	v, err := store.Get(context.Background(), testServiceARN, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestExecutorKeepsStateInStore(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours+" warn=15.", 1, mondayAt(17, 50))
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	ex.SetStateStore(store)
+	if err := ex.Snooze(testServiceARN, "30"); err != nil {
+		t.Fatal(err)
+	}
+	until, _ := NewServiceState(store).GetTime(Service{ARN: testServiceARN}, snoozedStateKey)
+	if got, want := until, ex.clock().Add(30*time.Minute).Truncate(time.Second); !got.Equal(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(fake.services[testServiceARN].tags), 0; got != want {
+		t.Errorf("got %v tags want %v", got, want)
+	}
+}
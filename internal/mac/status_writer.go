@@ -1,14 +1,16 @@
 package mac
 
 import (
+	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
 	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/emicklei/tre"
 )
 
@@ -16,9 +18,20 @@ import (
 var statusHTML string
 
 type StatusWriter struct {
-	client *ecs.Client
+	client    ECSAPI
+	weekPlan  *WeekPlan        // for the next transition, if any
+	state     *ServiceState    // for the start of a drift, read only
+	csrfToken string           // for posting actions
+	refresh   time.Duration    // if not zero, the rows are updated in place this often
+	clock     func() time.Time // time.Now if nil
 }
 
+//go:embed assets/status_row.html
+var statusRowHTML string
+
+//go:embed assets/live.html
+var liveHTML string
+
 func (r *StatusWriter) statusTemplate() (*template.Template, error) {
 	tmpl := template.New("status")
 	tmpl = tmpl.Funcs(template.FuncMap{
@@ -30,82 +43,150 @@ func (r *StatusWriter) statusTemplate() (*template.Template, error) {
 				return s
 			}
 		}})
-	tmpl, err := tmpl.Parse(statusHTML)
-	if err != nil {
-		return nil, tre.New(err, "parse template fail")
+	for _, each := range []string{statusHTML, statusRowHTML, liveHTML} {
+		if _, err := tmpl.Parse(each); err != nil {
+			return nil, tre.New(err, "parse template fail")
+		}
 	}
 	return tmpl, nil
 }
 
+func (r *StatusWriter) now() time.Time {
+	if r.clock == nil {
+		return time.Now().In(userLocation)
+	}
+	return r.clock().In(userLocation)
+}
+
 func (r *StatusWriter) WriteOn(plans []*ServicePlan, w io.Writer) error {
 	tmpl, err := r.statusTemplate()
 	if err != nil {
 		return err
 	}
-	now := time.Now().In(userLocation)
-	wd := WeekData{}
+	now := r.now()
+	wd := WeekData{CSRFToken: r.csrfToken, RefreshSeconds: int(r.refresh.Seconds())}
 	dd := DayData{}
 	day := now.Weekday()
 	dd.DayNumber = int(day)
 	dd.Name = day.String() + " , " + now.Format(time.RFC3339)
 
 	for _, each := range plans {
-		howMany, status := ServiceStatus(r.client, each.Service)
-		if status == "UNKNOWN" {
-			status = Stopped
-		}
-		rowClass := "running"
-		if status == Stopped {
-			rowClass = "stopped"
-		}
-		if each.Disabled {
-			rowClass = "disabled"
+		dd.Times = append(dd.Times, r.rowData(each, now))
+	}
+	wd.Days = append(wd.Days, dd)
+
+	return tre.New(tmpl.Execute(w, wd), "template exec fail")
+}
+
+// rowData returns the data of the status row of the service of a plan.
+func (r *StatusWriter) rowData(each *ServicePlan, now time.Time) TimeData {
+	info, err := DescribeServiceInfo(r.client, each.Service)
+	if err != nil {
+		slog.Warn("failed to describe service", "name", each.Name(), "err", err)
+	}
+	status := info.State()
+	rowClass := "running"
+	if info.DesiredCount == 0 {
+		rowClass = "stopped"
+	}
+	if each.Disabled {
+		rowClass = "disabled"
+	}
+	var drift Drift
+	if err == nil {
+		since := time.Time{}
+		if r.state != nil {
+			since, _ = r.state.GetTime(each.Service, driftSinceStateKey)
 		}
-		timeData := TimeData{
-			RowClass:   rowClass,
-			TasksCount: howMany,
-			Savings:    fmt.Sprintf("%d%%", 100-int(each.PercentageRunning()*100.0)),
-			Plan: &TimePlan{
-				DesiredState: status,
-				Hour:         now.Hour(),
-				Minute:       now.Minute(),
-			},
-			ServiceName: each.Name(),
-			ClusterName: each.ClusterName(),
-			Cron:        each.CronLabel(),
+		drift = newDrift(r.weekPlan, each, info, since, now)
+	}
+	hasDrift := drift.Kind != ""
+	if hasDrift {
+		rowClass += " drift"
+	}
+	timeData := TimeData{
+		RowClass:   rowClass,
+		TasksCount: info.RunningCount,
+		Info:       info,
+		Savings:    fmt.Sprintf("%d%%", 100-int(each.PercentageRunning()*100.0)),
+		Plan: &TimePlan{
+			DesiredState: status,
+			Hour:         now.Hour(),
+			Minute:       now.Minute(),
+		},
+		ServiceName: each.Name(),
+		ClusterName: each.ClusterName(),
+		PageURL:     servicePageURL(each.Service),
+		RowID:       "status-" + each.ARN,
+		CSRFToken:   r.csrfToken,
+		Cron:        each.CronLabel(),
+		Source:      each.SourceLabel(),
+	}
+	if hasDrift {
+		timeData.Drift = drift.String()
+	}
+	if r.weekPlan != nil {
+		if event, ok := r.weekPlan.NextEventAfter(each.Service, now); ok {
+			timeData.Next = fmt.Sprintf("%s (%d) at %s", event.DesiredState, event.DesiredCount, event.At.Format("Mon 15:04"))
 		}
-		if status == Stopped {
-			link := LinkData{Href: template.URL(fmt.Sprintf("?do=start&service-arn=%s", each.Service.ARN)), Title: "Start service"}
+	}
+	if info.DesiredCount == 0 {
+		link := LinkData{Fields: map[string]string{"do": "start", "service-arn": each.Service.ARN}, Title: "Start service"}
+		timeData.Links = append(timeData.Links, link)
+	} else {
+		link := LinkData{
+			Fields: map[string]string{"do": "stop", "service-arn": each.Service.ARN},
+			Title:  "Stop service"}
+		timeData.Links = append(timeData.Links, link)
+
+	}
+	link := LinkData{Href: template.URL(each.TagsURL()), Title: "Manage tags"}
+	timeData.Links = append(timeData.Links, link)
+
+	// Up or downscale
+	if info.DesiredCount > 0 {
+		// check against desired count
+		desired := r.weekPlan.ScheduledCountAt(each, now)
+		if desired > info.DesiredCount {
+			link := LinkData{
+				Fields: map[string]string{"do": "change-count", "service-arn": each.Service.ARN, "count": strconv.Itoa(desired)},
+				Title:  fmt.Sprintf("Upscale (%d) service", desired)}
 			timeData.Links = append(timeData.Links, link)
-		} else {
+		} else if info.DesiredCount > 1 {
 			link := LinkData{
-				Href:  template.URL(fmt.Sprintf("?do=stop&service-arn=%s", each.Service.ARN)),
-				Title: "Stop service"}
+				Fields: map[string]string{"do": "change-count", "service-arn": each.Service.ARN, "count": "1"},
+				Title:  "Downscale (1) service"}
 			timeData.Links = append(timeData.Links, link)
-
 		}
-		link := LinkData{Href: template.URL(each.TagsURL()), Title: "Manage tags"}
-		timeData.Links = append(timeData.Links, link)
+	}
+	return timeData
+}
 
-		// Up or downscale
-		if status == Running {
-			// check against desired count
-			desired := each.DesiredCountAt(now)
-			if desired > howMany {
-				link := LinkData{
-					Href:  template.URL(fmt.Sprintf("?do=change-count&service-arn=%ss&count=%d", each.Service.ARN, desired)),
-					Title: fmt.Sprintf("Upscale (%d) service", desired)}
-				timeData.Links = append(timeData.Links, link)
-			} else if howMany > 1 {
-				link := LinkData{
-					Href:  template.URL(fmt.Sprintf("?do=change-count&service-arn=%s&count=1", each.Service.ARN)),
-					Title: "Downscale (1) service"}
-				timeData.Links = append(timeData.Links, link)
-			}
+// statusRow is a rendered status row, to replace the row with the same id.
+type statusRow struct {
+	ID         string `json:"id"`
+	ServiceARN string `json:"service-arn"`
+	HTML       string `json:"html"`
+}
+
+// WriteRowsOn writes the status rows of the plans as JSON, for updating the status page in place.
+func (r *StatusWriter) WriteRowsOn(plans []*ServicePlan, w io.Writer) error {
+	tmpl, err := r.statusTemplate()
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	rows := []statusRow{}
+	for _, each := range plans {
+		html := new(bytes.Buffer)
+		data := r.rowData(each, now)
+		if err := tmpl.ExecuteTemplate(html, "status-row", data); err != nil {
+			return tre.New(err, "template exec fail")
 		}
-		dd.Times = append(dd.Times, timeData)
+		rows = append(rows, statusRow{ID: data.RowID, ServiceARN: each.ARN, HTML: html.String()})
 	}
-	wd.Days = append(wd.Days, dd)
-
-	return tre.New(tmpl.Execute(w, wd), "template exec fail")
+	return json.NewEncoder(w).Encode(struct {
+		Time time.Time   `json:"time"`
+		Rows []statusRow `json:"rows"`
+	}{Time: now, Rows: rows})
 }
@@ -0,0 +1,121 @@
+package mac
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Modes of stopping a service
+const (
+	StopForce    = "force"    // scale to zero and stop all tasks right away
+	StopGraceful = "graceful" // scale to zero and let ECS drain the tasks
+	StopDrain    = "drain"    // scale to zero and stop the tasks that are still running after the drain timeout
+)
+
+// DefaultDrainTimeout is the drain timeout if none is given.
+const DefaultDrainTimeout = 5 * time.Minute
+
+// stoppedStateKey is the state with the time a service was scaled to zero with StopDrain.
+const stoppedStateKey = "moneypenny-stopped-at"
+
+// StopStrategy tells how a service is stopped, e.g. drain 10 (minutes).
+type StopStrategy struct {
+	Mode         string
+	DrainTimeout time.Duration // for StopDrain
+}
+
+// DefaultStopStrategy is used if the plan has none.
+var DefaultStopStrategy = StopStrategy{Mode: StopForce}
+
+func (s StopStrategy) String() string {
+	if s.Mode == StopDrain {
+		return fmt.Sprintf("%s %d", s.Mode, int(s.DrainTimeout.Minutes()))
+	}
+	return s.Mode
+}
+
+// ParseStopStrategy parses force, graceful, drain or drain with the timeout in minutes, e.g. drain 10.
+// Errors are *TagSyntaxError with the column in the input.
+func ParseStopStrategy(input string) (StopStrategy, error) {
+	s := newTagScanner(input)
+	strategy, err := s.parseStopStrategy()
+	if err != nil {
+		return strategy, err
+	}
+	if end := s.next(); end.kind != tokenEOF {
+		return strategy, s.errorAt(end, "expected end of stop strategy, got %s", end)
+	}
+	return strategy, nil
+}
+
+func (s *tagScanner) parseStopStrategy() (StopStrategy, error) {
+	t := s.next()
+	if t.kind != tokenWord {
+		return DefaultStopStrategy, s.errorAt(t, "expected force, graceful or drain, got %s", t)
+	}
+	switch t.text {
+	case StopForce, StopGraceful:
+		return StopStrategy{Mode: t.text}, nil
+	case StopDrain:
+		strategy := StopStrategy{Mode: StopDrain, DrainTimeout: DefaultDrainTimeout}
+		if s.peek().kind == tokenNumber {
+			minutes, err := s.parseNumber("drain timeout", 1, minutesPerDay)
+			if err != nil {
+				return strategy, err
+			}
+			strategy.DrainTimeout = time.Duration(minutes) * time.Minute
+		}
+		return strategy, nil
+	}
+	return DefaultStopStrategy, s.errorAt(t, "unknown stop strategy %q, expected force, graceful or drain", t.text)
+}
+
+// stopService stops the service using the strategy.
+func (p *PlanExecutor) stopService(service Service, strategy StopStrategy) error {
+	if strategy.Mode == StopForce {
+		return StopService(p.client, service)
+	}
+	slog.Info("scaling down service", "arn", service.ARN, "stop", strategy)
+	if err := ChangeTaskCountOfService(p.client, service, 0); err != nil {
+		return err
+	}
+	if strategy.Mode == StopDrain {
+		return p.state.SetTime(service, stoppedStateKey, p.clock())
+	}
+	return nil
+}
+
+// drainTimedOut returns true if the service was scaled down to zero with StopDrain longer than the drain timeout ago.
+func (p *PlanExecutor) drainTimedOut(service Service, strategy StopStrategy, now time.Time) bool {
+	if strategy.Mode != StopDrain {
+		return false
+	}
+	stoppedAt, err := p.state.GetTime(service, stoppedStateKey)
+	if err != nil || stoppedAt.IsZero() {
+		return false
+	}
+	return !now.Before(stoppedAt.Add(strategy.DrainTimeout))
+}
+
+// clearDrainStart forgets when the service was scaled down with StopDrain, if it was.
+// It is called whenever the service is started, rescaled or has no running tasks, so a later stop gets the full drain timeout.
+func (p *PlanExecutor) clearDrainStart(service Service) {
+	stoppedAt, err := p.state.GetTime(service, stoppedStateKey)
+	if err != nil || stoppedAt.IsZero() {
+		return
+	}
+	if err := p.state.Clear(service, stoppedStateKey); err != nil {
+		slog.Error("failed to clear drain start", "name", service.Name(), "err", err)
+	}
+}
+
+// stopStrategyOf returns the stop strategy of the plan of the service or DefaultStopStrategy if it has no plan.
+func (p *PlanExecutor) stopStrategyOf(service Service) StopStrategy {
+	for _, each := range p.plans {
+		if each.ARN == service.ARN {
+			return each.StopStrategy()
+		}
+	}
+	return DefaultStopStrategy
+}
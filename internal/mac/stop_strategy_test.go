@@ -0,0 +1,151 @@
+package mac
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStopStrategy(t *testing.T) {
+	for _, each := range []struct {
+		input, want string
+		timeout     time.Duration
+	}{
+		{"force", "force", 0},
+		{"graceful", "graceful", 0},
+		{"drain", "drain 5", DefaultDrainTimeout},
+		{"drain 10", "drain 10", 10 * time.Minute},
+	} {
+		s, err := ParseStopStrategy(each.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s.String(), each.want; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+		if got, want := s.DrainTimeout, each.timeout; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	}
+	for _, each := range []string{"", "kill", "drain 0", "graceful 10"} {
+		if _, err := ParseStopStrategy(each); err == nil {
+			t.Errorf("expected error for %q", each)
+		}
+	}
+}
+
+func TestServicePlanStopStrategyFromTag(t *testing.T) {
+	sp := newTestPlan(t, testServiceARN, "stopped=0 18 1-5. stop=drain 10.")
+	if got, want := sp.StopStrategy().String(), "drain 10"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := sp.Stop, "drain 10"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := newTestPlan(t, testServiceARN, "stopped=0 18 1-5.").StopStrategy(), DefaultStopStrategy; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestServicePlanStopStrategyInvalid(t *testing.T) {
+	sp := &ServicePlan{Service: Service{ARN: testServiceARN}, TagValue: "stopped=0 18 1-5.", Stop: "kill"}
+	if err := sp.Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestExecutorStopGraceful(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours+" stop=graceful.", 2, mondayAt(18, 5))
+	fake.services[testServiceARN].draining = true
+	ex.Plan()
+	if got, want := ex.Changes()[0].String(), "stop dev/api (2 -> 0) using graceful"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	ex.Apply()
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(fake.stopped), 0; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+	// still draining, nothing to do
+	ex.Apply()
+	if got, want := ex.Changes()[0].Action, ActionNone; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorStopDrainThenForce(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours+" stop=drain 10.", 2, mondayAt(18, 5))
+	fake.services[testServiceARN].draining = true
+	ex.Apply()
+	if got, want := len(fake.stopped), 0; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, stoppedStateKey); got == "" {
+		t.Error("expected drain start tag")
+	}
+	*now = now.Add(5 * time.Minute)
+	ex.Apply()
+	if got, want := ex.Changes()[0].Action, ActionNone; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	*now = now.Add(5 * time.Minute)
+	ex.Apply()
+	change := ex.Changes()[0]
+	if got, want := change.String(), "stop dev/api (2 -> 0) using force: tasks still running after drain timeout of 10m0s"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(fake.stopped), 2; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, stoppedStateKey); got != "" {
+		t.Errorf("got %v want empty drain start tag", got)
+	}
+}
+
+func TestExecutorStopDefaultIsForce(t *testing.T) {
+	ex, fake, _ := newExecutorTest(t, officeHours+" stop=force.", 2, mondayAt(18, 5))
+	fake.services[testServiceARN].draining = true
+	ex.plans[0] = newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5.")
+	ex.Apply()
+	if got, want := len(fake.stopped), 2; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+}
+
+func TestExecutorStaleDrainStartIsCleared(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours+" stop=drain 10.", 2, mondayAt(18, 5))
+	fake.services[testServiceARN].draining = true
+	svc := Service{ARN: testServiceARN}
+	ex.state.SetTime(svc, stoppedStateKey, now.Add(-72*time.Hour))
+	// running as scheduled
+	*now = now.Add(-time.Hour)
+	ex.Apply()
+	if got, _ := ex.state.Get(svc, stoppedStateKey); got != "" {
+		t.Errorf("got %v want empty drain start", got)
+	}
+	// scaled down outside moneypenny, still draining
+	fake.services[testServiceARN].desired = 0
+	*now = now.Add(time.Hour)
+	ex.Apply()
+	if got, want := ex.Changes()[0].Action, ActionNone; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := len(fake.stopped), 0; got != want {
+		t.Errorf("got %v stopped tasks want %v", got, want)
+	}
+}
+
+func TestStartClearsDrainStart(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours+" stop=drain 10.", 2, mondayAt(18, 5))
+	fake.services[testServiceARN].draining = true
+	svc := Service{ARN: testServiceARN}
+	ex.state.SetTime(svc, stoppedStateKey, now.Add(-72*time.Hour))
+	if err := ex.Start(testServiceARN); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ex.state.Get(svc, stoppedStateKey); got != "" {
+		t.Errorf("got %v want empty drain start", got)
+	}
+}
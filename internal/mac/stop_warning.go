@@ -0,0 +1,67 @@
+package mac
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// SnoozeMinutes is the default time a scheduled stop is postponed by a snooze.
+const SnoozeMinutes = 60
+
+// StopWarning tells that a running service will be stopped soon by its schedule.
+type StopWarning struct {
+	Service
+	StopAt    time.Time
+	Now       time.Time
+	SnoozeURL string // empty if no status URL is known
+}
+
+func (w StopWarning) String() string {
+	return fmt.Sprintf("%s/%s will be stopped at %s (in %d minutes)",
+		w.ClusterName(), w.Name(), w.StopAt.In(userLocation).Format("Mon 15:04"), int(w.StopAt.Sub(w.Now).Round(time.Minute).Minutes()))
+}
+
+// snoozeURL returns the link on the status page that postpones the stop of the service.
+func snoozeURL(statusURL string, service Service) string {
+	if statusURL == "" {
+		return ""
+	}
+	q := url.Values{}
+	q.Set("do", "snooze")
+	q.Set("service-arn", service.ARN)
+	q.Set("minutes", fmt.Sprint(SnoozeMinutes))
+	return statusURL + "?" + q.Encode()
+}
+
+// stopWarning returns a warning if the next stop of the service is within the warn minutes of its plan
+// and no warning was sent for that stop yet. Sending is remembered in the state of the service.
+func (p *PlanExecutor) stopWarning(plan *ServicePlan, now time.Time) (StopWarning, bool) {
+	if plan.WarnMinutes <= 0 {
+		return StopWarning{}, false
+	}
+	stopAt, ok := p.weekPlan.NextStopAfter(plan.Service, now)
+	if !ok || stopAt.Sub(now) > time.Duration(plan.WarnMinutes)*time.Minute {
+		return StopWarning{}, false
+	}
+	clog := slog.With("name", plan.Name(), "stop-at", stopAt)
+	if until, _ := p.state.GetTime(plan.Service, snoozedStateKey); until.After(stopAt) {
+		clog.Info("stop is snoozed, no warning", "until", until)
+		return StopWarning{}, false
+	}
+	sent, err := p.state.GetTime(plan.Service, warnedStateKey)
+	if err != nil {
+		clog.Error("failed to read warning state", "err", err)
+		return StopWarning{}, false
+	}
+	if sent.Equal(stopAt) {
+		clog.Debug("stop warning already sent")
+		return StopWarning{}, false
+	}
+	if err := p.state.SetTime(plan.Service, warnedStateKey, stopAt); err != nil {
+		clog.Error("failed to remember warning", "err", err)
+	}
+	clog.Info("warning before stop", "warn-minutes", plan.WarnMinutes)
+	return StopWarning{Service: plan.Service, StopAt: stopAt, Now: now, SnoozeURL: snoozeURL(p.statusURL, plan.Service)}, true
+}
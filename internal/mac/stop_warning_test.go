@@ -0,0 +1,91 @@
+package mac
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServiceARN = "arn:aws:ecs:eu-central-1:9111111:service/dev/api"
+
+func TestParseWarnMinutes(t *testing.T) {
+	sp := newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5. warn=15.")
+	if got, want := sp.WarnMinutes, 15; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if _, err := ParseStateChanges("warn=0."); err == nil {
+		t.Error("expected range error")
+	}
+}
+
+func TestNextStopAfter(t *testing.T) {
+	sp := newTestPlan(t, testServiceARN, "running=0 8 1-5. stopped=0 18 1-5.")
+	wp := new(WeekPlan)
+	wp.AddServicePlan(*sp)
+	// Friday 19:00
+	at, ok := wp.NextStopAfter(sp.Service, time.Date(2024, 4, 5, 19, 0, 0, 0, time.UTC))
+	if !ok {
+		t.Fatal("expected stop")
+	}
+	if got, want := at, time.Date(2024, 4, 8, 18, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorStopWarningOnce(t *testing.T) {
+	ex, _, _ := newExecutorTest(t, officeHours+" warn=15.", 1, mondayAt(17, 50))
+	ex.SetStatusURL("https://example.com/moneypenny")
+	srv, bodies := webhookReceiver(t, 200)
+	n, _ := NewWebhookNotifier(NotifierSlack, srv.URL, "")
+	ex.AddNotifier(n)
+	ex.Apply()
+	ex.Apply()
+	if got, want := len(*bodies), 1; got != want {
+		t.Fatalf("got %v notifications want %v", got, want)
+	}
+	for _, each := range []string{"dev/api will be stopped at Mon 18:00 (in 10 minutes)", "do=snooze"} {
+		if !strings.Contains((*bodies)[0], each) {
+			t.Errorf("missing %q in %s", each, (*bodies)[0])
+		}
+	}
+}
+
+func TestExecutorNoStopWarningTooEarly(t *testing.T) {
+	ex, _, now := newExecutorTest(t, officeHours+" warn=15.", 1, mondayAt(17, 50))
+	*now = now.Add(-time.Hour)
+	ex.Apply()
+	if got, want := len(ex.warnings), 0; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorSnoozedStop(t *testing.T) {
+	ex, fake, now := newExecutorTest(t, officeHours+" warn=15.", 1, mondayAt(17, 50))
+	if err := ex.Snooze(testServiceARN, "30"); err != nil {
+		t.Fatal(err)
+	}
+	// 18:05, snoozed until 18:20
+	*now = now.Add(15 * time.Minute)
+	ex.Apply()
+	if got, want := len(fake.updates), 0; got != want {
+		t.Errorf("got %v updates want %v", got, want)
+	}
+	// 18:25
+	*now = now.Add(20 * time.Minute)
+	ex.Apply()
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestExecutorSnoozeOutOfRange(t *testing.T) {
+	ex, _, _ := newExecutorTest(t, officeHours+" warn=15.", 1, mondayAt(17, 50))
+	for _, each := range []string{"0", "-5", "1441", "abc"} {
+		if err := ex.Snooze(testServiceARN, each); err == nil {
+			t.Errorf("expected error for %q", each)
+		}
+	}
+	if got, _ := ex.state.Get(Service{ARN: testServiceARN}, snoozedStateKey); got != "" {
+		t.Errorf("got %v want empty", got)
+	}
+}
@@ -0,0 +1,143 @@
+package mac
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// TagDiagnostic is an error or a warning about a moneypenny tag value.
+type TagDiagnostic struct {
+	Severity string
+	Column   int // 1-based position in the tag value, 0 if not specific
+	Message  string
+}
+
+func (d TagDiagnostic) String() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("column %d: %s: %s", d.Column, d.Severity, d.Message)
+}
+
+// LintTagValue returns the syntax error or the warnings for suspicious state changes.
+func LintTagValue(input string) (list []TagDiagnostic) {
+	stmts, err := parseTag(input)
+	if err != nil {
+		return append(list, tagErrorDiagnostic(err))
+	}
+	columns := map[*StateChange]int{}
+	changes := []*StateChange{}
+	hasRunning, hasStopped := false, false
+	var warn, stop *tagStatement
+	for i, each := range stmts {
+		switch each.name {
+		case "running", "stopped":
+			columns[each.change] = each.column
+			changes = append(changes, each.change)
+			hasRunning = hasRunning || each.name == "running"
+			hasStopped = hasStopped || each.name == "stopped"
+		case "count":
+			if !hasRunning {
+				list = append(list, TagDiagnostic{Severity: SeverityWarning, Column: each.column,
+					Message: "count without a preceding running state change has no effect"})
+			}
+		case "warn":
+			warn = &stmts[i]
+		case "stop":
+			stop = &stmts[i]
+		}
+	}
+	if stop != nil && !hasStopped {
+		list = append(list, TagDiagnostic{Severity: SeverityWarning, Column: stop.column,
+			Message: "stop without a stopped state change has no effect"})
+	}
+	if warn != nil && !hasStopped {
+		list = append(list, TagDiagnostic{Severity: SeverityWarning, Column: warn.column,
+			Message: "warn without a stopped state change has no effect"})
+	}
+	return append(list, lintStateChanges(changes, columns)...)
+}
+
+// lintStateChanges returns warnings for suspicious state changes.
+func lintStateChanges(changes []*StateChange, columns map[*StateChange]int) (list []TagDiagnostic) {
+	var firstRun, firstStop *StateChange
+	for _, each := range changes {
+		if each.DesiredState == Running && firstRun == nil {
+			firstRun = each
+		}
+		if each.DesiredState == Stopped && firstStop == nil {
+			firstStop = each
+		}
+	}
+	if firstRun != nil && firstStop == nil {
+		list = append(list, TagDiagnostic{Severity: SeverityWarning, Column: columns[firstRun],
+			Message: "no stopped state change, the service is never stopped"})
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		run, stop := firstChangeOn(changes, d, Running), firstChangeOn(changes, d, Stopped)
+		if run == nil || stop == nil {
+			continue
+		}
+		if minuteOfDay(stop.CronSpec) < minuteOfDay(run.CronSpec) {
+			list = append(list, TagDiagnostic{Severity: SeverityWarning, Column: columns[stop],
+				Message: fmt.Sprintf("on %s the service is stopped at %s before it is started at %s", d, hourMinute(stop.CronSpec), hourMinute(run.CronSpec))})
+		}
+	}
+	return
+}
+
+func firstChangeOn(changes []*StateChange, day time.Weekday, state string) (first *StateChange) {
+	for _, each := range changes {
+		if each.DesiredState != state || !each.CronSpec.IsEffectiveOnWeekday(day) {
+			continue
+		}
+		if first == nil || minuteOfDay(each.CronSpec) < minuteOfDay(first.CronSpec) {
+			first = each
+		}
+	}
+	return
+}
+
+func minuteOfDay(c CronSpec) int { return c.Hour*60 + c.Minute }
+
+func hourMinute(c CronSpec) string { return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute) }
+
+func tagErrorDiagnostic(err error) TagDiagnostic {
+	if syntaxErr, ok := err.(*TagSyntaxError); ok {
+		return TagDiagnostic{Severity: SeverityError, Column: syntaxErr.Column, Message: syntaxErr.Message}
+	}
+	return TagDiagnostic{Severity: SeverityError, Message: err.Error()}
+}
+
+// LintServicePlan returns the diagnostics of the tag value or the state changes of a plan.
+func LintServicePlan(plan *ServicePlan) []TagDiagnostic {
+	if value := plan.tagValue(); value != "" {
+		return LintTagValue(value)
+	}
+	return lintStateChanges(plan.StateChanges, nil)
+}
+
+// PlanDiagnostic is a diagnostic of a service plan.
+type PlanDiagnostic struct {
+	Plan *ServicePlan
+	TagDiagnostic
+}
+
+func (d PlanDiagnostic) String() string {
+	return fmt.Sprintf("%s/%s [%s]: %s", d.Plan.ClusterName(), d.Plan.Name(), d.Plan.SourceLabel(), d.TagDiagnostic)
+}
+
+// LintServicePlans returns the diagnostics of all plans, also those that are disabled.
+func LintServicePlans(plans []*ServicePlan) (list []PlanDiagnostic) {
+	for _, each := range plans {
+		for _, other := range LintServicePlan(each) {
+			list = append(list, PlanDiagnostic{Plan: each, TagDiagnostic: other})
+		}
+	}
+	return
+}
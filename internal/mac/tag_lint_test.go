@@ -0,0 +1,43 @@
+package mac
+
+import (
+	"testing"
+)
+
+func TestLintTagValue(t *testing.T) {
+	for _, each := range []struct {
+		input string
+		want  []string
+	}{
+		{"running=0 8 1-5. stopped=0 18 1-5.", nil},
+		{"running=0 8 1-5.", []string{"column 1: warning: no stopped state change, the service is never stopped"}},
+		{"stopped=0 18 1-5. count=2", []string{"column 19: warning: count without a preceding running state change has no effect"}},
+		{"running=0 18 1. stopped=0 8 1.", []string{"column 17: warning: on Monday the service is stopped at 08:00 before it is started at 18:00"}},
+		{"running=0 25 1.", []string{"column 11: error: hour 25 out of range 0..23"}},
+		{"stopped=0 0 0-6.", nil},
+		{"running=0 8 1-5. warn=15.", []string{"column 18: warning: warn without a stopped state change has no effect", "column 1: warning: no stopped state change, the service is never stopped"}},
+		{"running=0 8 1-5. stop=graceful.", []string{"column 18: warning: stop without a stopped state change has no effect", "column 1: warning: no stopped state change, the service is never stopped"}},
+	} {
+		got := LintTagValue(each.input)
+		if len(got) != len(each.want) {
+			t.Errorf("%s: got %v want %v", each.input, got, each.want)
+			continue
+		}
+		for i, d := range got {
+			if d.String() != each.want[i] {
+				t.Errorf("%s: got %v want %v", each.input, d, each.want[i])
+			}
+		}
+	}
+}
+
+func TestLintServicePlanStateChanges(t *testing.T) {
+	sp := &ServicePlan{StateChanges: []*StateChange{{DesiredState: "running", Cron: "0 8 1-5"}}}
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	got := LintServicePlan(sp)
+	if len(got) != 1 || got[0].Severity != SeverityWarning {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,253 @@
+package mac
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// TagSyntaxError is an error in a moneypenny tag value or cron expression.
+type TagSyntaxError struct {
+	Column  int // 1-based position in the input
+	Message string
+}
+
+func (e *TagSyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+type tagTokenKind int
+
+const (
+	tokenEOF tagTokenKind = iota
+	tokenWord
+	tokenNumber
+	tokenEquals
+	tokenDot
+	tokenDash
+	tokenSlash
+	tokenComment // the rest of the input
+	tokenIllegal
+)
+
+type tagToken struct {
+	kind   tagTokenKind
+	text   string
+	column int // 1-based
+}
+
+func (t tagToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(t.text)
+}
+
+// tagScanner splits a tag value into tokens; whitespace separates tokens.
+type tagScanner struct {
+	input []rune
+	pos   int
+	ahead *tagToken
+}
+
+func newTagScanner(input string) *tagScanner {
+	return &tagScanner{input: []rune(input)}
+}
+
+func (s *tagScanner) peek() tagToken {
+	if s.ahead == nil {
+		t := s.scan()
+		s.ahead = &t
+	}
+	return *s.ahead
+}
+
+func (s *tagScanner) next() tagToken {
+	t := s.peek()
+	s.ahead = nil
+	return t
+}
+
+func (s *tagScanner) scan() tagToken {
+	for s.pos < len(s.input) && unicode.IsSpace(s.input[s.pos]) {
+		s.pos++
+	}
+	start := s.pos
+	if start == len(s.input) {
+		return tagToken{kind: tokenEOF, column: start + 1}
+	}
+	token := func(kind tagTokenKind) tagToken {
+		return tagToken{kind: kind, text: string(s.input[start:s.pos]), column: start + 1}
+	}
+	r := s.input[s.pos]
+	s.pos++
+	switch {
+	case r == '/' && s.pos < len(s.input) && s.input[s.pos] == '/':
+		s.pos = len(s.input)
+		return token(tokenComment)
+	case r == '=':
+		return token(tokenEquals)
+	case r == '.':
+		return token(tokenDot)
+	case r == '-':
+		return token(tokenDash)
+	case r == '/':
+		return token(tokenSlash)
+	case unicode.IsDigit(r):
+		for s.pos < len(s.input) && unicode.IsDigit(s.input[s.pos]) {
+			s.pos++
+		}
+		return token(tokenNumber)
+	case unicode.IsLetter(r):
+		for s.pos < len(s.input) && (unicode.IsLetter(s.input[s.pos]) || unicode.IsDigit(s.input[s.pos])) {
+			s.pos++
+		}
+		return token(tokenWord)
+	}
+	return token(tokenIllegal)
+}
+
+func (s *tagScanner) errorAt(t tagToken, format string, args ...any) error {
+	return &TagSyntaxError{Column: t.column, Message: fmt.Sprintf(format, args...)}
+}
+
+// tagStatement is one name=value statement of a tag value.
+type tagStatement struct {
+	name   string
+	column int
+	change *StateChange // for running and stopped
+	value  int          // for count and warn
+	stop   StopStrategy // for stop
+}
+
+// parseTag parses statements until the end of the input or a comment.
+//
+//	tag       := { statement } [ "//" comment ]
+//	statement := name "=" value ( "." | end )
+//	value     := cron | number
+//	cron      := minute hour days
+//	days      := day [ "-" day ] { "/" day [ "-" day ] }
+func parseTag(input string) (list []tagStatement, err error) {
+	s := newTagScanner(input)
+	for {
+		name := s.next()
+		switch name.kind {
+		case tokenEOF, tokenComment:
+			return
+		case tokenDot:
+			continue // empty statement
+		case tokenWord:
+		default:
+			return list, s.errorAt(name, "expected running, stopped, count, warn or stop, got %s", name)
+		}
+		if eq := s.next(); eq.kind != tokenEquals {
+			return list, s.errorAt(eq, "expected = after %s, got %s", name.text, eq)
+		}
+		stmt := tagStatement{name: name.text, column: name.column}
+		switch name.text {
+		case "running", "stopped":
+			spec, cron, err := s.parseCron()
+			if err != nil {
+				return list, err
+			}
+			stmt.change = &StateChange{DesiredState: Stopped, Cron: cron, CronSpec: spec}
+			if name.text == "running" {
+				stmt.change.DesiredState = Running
+				stmt.change.DesiredCount = 1
+			}
+		case "count":
+			n, err := s.parseNumber("count", 0, 1000)
+			if err != nil {
+				return list, err
+			}
+			stmt.value = n
+		case "stop":
+			strategy, err := s.parseStopStrategy()
+			if err != nil {
+				return list, err
+			}
+			stmt.stop = strategy
+		case "warn":
+			n, err := s.parseNumber("warn", 1, minutesPerDay)
+			if err != nil {
+				return list, err
+			}
+			stmt.value = n
+		default:
+			return list, s.errorAt(name, "unknown state %q, expected running, stopped, count, warn or stop", name.text)
+		}
+		list = append(list, stmt)
+		switch end := s.next(); end.kind {
+		case tokenDot:
+		case tokenEOF, tokenComment:
+			return list, nil
+		default:
+			return list, s.errorAt(end, "expected . after %s statement, got %s", name.text, end)
+		}
+	}
+}
+
+// parseNumber reads a number and checks its range.
+func (s *tagScanner) parseNumber(what string, min, max int) (int, error) {
+	t := s.next()
+	if t.kind != tokenNumber {
+		return 0, s.errorAt(t, "expected %s (%d..%d), got %s", what, min, max, t)
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil || n < min || n > max {
+		return 0, s.errorAt(t, "%s %s out of range %d..%d", what, t.text, min, max)
+	}
+	return n, nil
+}
+
+// parseCron reads minute hour days and returns the spec and the text it was parsed from.
+func (s *tagScanner) parseCron() (spec CronSpec, cron string, err error) {
+	start := s.peek().column - 1
+	if spec.Minute, err = s.parseNumber("minute", 0, 59); err != nil {
+		return
+	}
+	if spec.Hour, err = s.parseNumber("hour", 0, 23); err != nil {
+		return
+	}
+	for {
+		first := s.peek()
+		from, err := s.parseNumber("day of week", 0, 6)
+		if err != nil {
+			return spec, cron, err
+		}
+		to := from
+		if s.peek().kind == tokenDash {
+			s.next()
+			last := s.peek()
+			if to, err = s.parseNumber("day of week", 0, 6); err != nil {
+				return spec, cron, err
+			}
+			if to < from {
+				return spec, cron, s.errorAt(last, "reversed range %d-%d of days of week", from, to)
+			}
+		}
+		for d := from; d <= to; d++ {
+			if spec.IsEffectiveOnWeekday(time.Weekday(d)) {
+				return spec, cron, s.errorAt(first, "duplicate day of week %d", d)
+			}
+			spec.DaysOfWeek = append(spec.DaysOfWeek, time.Weekday(d))
+		}
+		if s.peek().kind != tokenSlash {
+			break
+		}
+		s.next()
+	}
+	end := s.pos
+	if s.ahead != nil {
+		end = s.ahead.column - 1
+	}
+	return spec, string(trimSpaceRunes(s.input[start:end])), nil
+}
+
+func trimSpaceRunes(r []rune) []rune {
+	for len(r) > 0 && unicode.IsSpace(r[len(r)-1]) {
+		r = r[:len(r)-1]
+	}
+	return r
+}
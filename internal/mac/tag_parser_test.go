@@ -0,0 +1,58 @@
+package mac
+
+import (
+	"testing"
+)
+
+func TestParseStateChangesErrors(t *testing.T) {
+	for _, each := range []struct {
+		input, err string
+	}{
+		{"running=0 25 1-5.", `column 11: hour 25 out of range 0..23`},
+		{"running=99 8 1-5.", `column 9: minute 99 out of range 0..59`},
+		{"running=0 8 9.", `column 13: day of week 9 out of range 0..6`},
+		{"running=0 8 5-1.", `column 15: reversed range 5-1 of days of week`},
+		{"running=0 8 1-3/2.", `column 17: duplicate day of week 2`},
+		{"running=0 8 1-5 stopped=0 18 1-5", `column 17: expected . after running statement, got "stopped"`},
+		{"runnin=0 8 1-5.", `column 1: unknown state "runnin", expected running, stopped, count, warn or stop`},
+		{"running 0 8 1-5.", `column 9: expected = after running, got "0"`},
+		{"running=0 8.", `column 12: expected day of week (0..6), got "."`},
+		{"running=0 8 1-5. count=x", `column 24: expected count (0..1000), got "x"`},
+		{"running=0 8 1-5. =", `column 18: expected running, stopped, count, warn or stop, got "="`},
+	} {
+		_, err := ParseStateChanges(each.input)
+		if err == nil {
+			t.Errorf("%s: error expected", each.input)
+			continue
+		}
+		if got := err.Error(); got != each.err {
+			t.Errorf("%s: got %v want %v", each.input, got, each.err)
+		}
+	}
+}
+
+func TestParseStateChangesCronText(t *testing.T) {
+	list, err := ParseStateChanges("running= 0  8 1-5 . stopped=0 18 1/3-5 // rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := list[0].Cron, "0  8 1-5"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := list[1].Cron, "0 18 1/3-5"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := len(list[1].CronSpec.DaysOfWeek), 4; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestParseCronSpecTrailing(t *testing.T) {
+	_, err := ParseCronSpec("0 8 1-5 x")
+	if err == nil {
+		t.Fatal("error expected")
+	}
+	if got, want := err.Error(), `column 9: unexpected "x" after cron expression`; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
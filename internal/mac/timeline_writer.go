@@ -0,0 +1,112 @@
+package mac
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/emicklei/tre"
+)
+
+//go:embed assets/timeline.svg
+var timelineSVG string
+
+// TimelineWriter writes the week plan as an SVG with a running/stopped bar per service.
+type TimelineWriter struct {
+	Now time.Time // if not zero, the current time is marked, in the user's timezone
+}
+
+const (
+	timelineLabelWidth = 240
+	timelineDayWidth   = 144 // 10 minutes per pixel
+	timelineRowHeight  = 24
+	timelineBarHeight  = 20
+	timelineHeader     = 20
+)
+
+type timelineData struct {
+	Width, Height, LabelWidth, WeekWidth, BarHeight int
+	Days                                            []timelineDay
+	Rows                                            []timelineRow
+	HasNow                                          bool
+	NowX                                            int
+	NowLabel                                        string
+}
+type timelineDay struct {
+	X    int
+	Name string
+}
+type timelineRow struct {
+	Y     int
+	Label string
+	Bars  []timelineBar
+}
+type timelineBar struct {
+	X, Y, Width  int
+	DesiredCount int
+	ShowCount    bool
+	Title        string
+}
+
+func (r TimelineWriter) WriteOn(wp *WeekPlan, w io.Writer) error {
+	tmpl, err := template.New("timeline").Parse(timelineSVG)
+	if err != nil {
+		return tre.New(err, "parse template fail")
+	}
+	return tre.New(tmpl.Execute(w, r.timelineData(wp)), "template exec fail")
+}
+
+func (r TimelineWriter) timelineData(wp *WeekPlan) timelineData {
+	weekWidth := 7 * timelineDayWidth
+	xOf := func(minuteOfWeek int) int {
+		return timelineLabelWidth + minuteOfWeek*timelineDayWidth/minutesPerDay
+	}
+	services := wp.Services()
+	data := timelineData{
+		Width:      timelineLabelWidth + weekWidth,
+		Height:     timelineHeader + len(services)*timelineRowHeight,
+		LabelWidth: timelineLabelWidth,
+		WeekWidth:  weekWidth,
+		BarHeight:  timelineBarHeight,
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		data.Days = append(data.Days, timelineDay{X: xOf(int(d) * minutesPerDay), Name: d.String()})
+	}
+	for i, each := range services {
+		row := timelineRow{
+			Y:     timelineHeader + i*timelineRowHeight,
+			Label: each.ClusterName() + "/" + each.Name(),
+		}
+		for _, win := range wp.RunningWindows(each) {
+			bar := timelineBar{
+				X:            xOf(win.Start),
+				Y:            row.Y,
+				Width:        xOf(win.End) - xOf(win.Start),
+				DesiredCount: win.DesiredCount,
+				Title:        fmt.Sprintf("%s running %s - %s, %d task(s)", row.Label, minuteOfWeekLabel(win.Start), minuteOfWeekLabel(win.End), win.DesiredCount),
+			}
+			bar.ShowCount = bar.Width >= 12
+			row.Bars = append(row.Bars, bar)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	if !r.Now.IsZero() {
+		now := r.Now.In(userLocation)
+		data.HasNow = true
+		data.NowX = xOf(int(now.Weekday())*minutesPerDay + now.Hour()*60 + now.Minute())
+		data.NowLabel = now.Format(time.RFC1123)
+	}
+	return data
+}
+
+// minuteOfWeekLabel returns e.g. Monday 08:00
+func minuteOfWeekLabel(minuteOfWeek int) string {
+	day := time.Weekday(minuteOfWeek / minutesPerDay % 7)
+	m := minuteOfWeek % minutesPerDay
+	if minuteOfWeek == minutesPerWeek {
+		day, m = time.Saturday, minutesPerDay
+	}
+	return fmt.Sprintf("%s %02d:%02d", day, m/60, m%60)
+}
@@ -0,0 +1,81 @@
+package mac
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunningWindowsOfficeTime(t *testing.T) {
+	svc := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 8 1-5. stopped=0 18 1-5. count=2."}
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	list := wp.RunningWindows(svc)
+	if got, want := len(list), 5; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	monday := RunningWindow{Start: minutesPerDay + 8*60, End: minutesPerDay + 18*60, DesiredCount: 2}
+	if got := list[0]; got != monday {
+		t.Errorf("got %v want %v", got, monday)
+	}
+}
+
+func TestRunningWindowsOvernight(t *testing.T) {
+	svc := Service{ARN: "night"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 22 0-6. stopped=0 6 0-6."}
+	if err := sp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	list := wp.RunningWindows(svc)
+	// Sunday 00:00-06:00, 6 nights and Saturday 22:00-24:00
+	if got, want := len(list), 8; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := list[0], (RunningWindow{Start: 0, End: 6 * 60, DesiredCount: 1}); got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got, want := list[7].End, minutesPerWeek; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestRunningWindowsAlways(t *testing.T) {
+	svc := Service{ARN: "always"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 0 0-6."}
+	sp.Validate()
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	list := wp.RunningWindows(svc)
+	if got, want := list, []RunningWindow{{Start: 0, End: minutesPerWeek, DesiredCount: 1}}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestTimelineWriter(t *testing.T) {
+	sp := ServicePlan{Service: Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/dev/api"}, TagValue: "running=0 8 1-5. stopped=0 18 1-5."}
+	sp.Validate()
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	buf := new(bytes.Buffer)
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.Local)
+	if err := (TimelineWriter{Now: now}).WriteOn(wp, buf); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if got, want := strings.Count(svg, `fill="#a3c4a6"`), 5; got != want {
+		t.Errorf("got %v running bars want %v", got, want)
+	}
+	if !strings.Contains(svg, "dev/api running Monday 08:00 - Monday 18:00, 1 task(s)") {
+		t.Error("missing bar title")
+	}
+	if !strings.Contains(svg, "now: ") {
+		t.Error("missing now marker")
+	}
+}
@@ -0,0 +1,159 @@
+package mac
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Roles of a user of the controls UI; each role may do what the previous role may do.
+const (
+	RoleViewer   = "viewer"   // pages, plan, schedule and drift detection
+	RoleOperator = "operator" // start, stop, change-count and snooze of a service
+	RoleAdmin    = "admin"    // apply and drift correction
+)
+
+var roleLevels = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// User is an account of the controls UI.
+type User struct {
+	Name         string   `json:"name" yaml:"name"`
+	PasswordHash string   `json:"password-hash" yaml:"password-hash"` // bcrypt, see HashPassword
+	Role         string   `json:"role" yaml:"role"`
+	Clusters     []string `json:"clusters,omitempty" yaml:"clusters,omitempty"` // names of clusters this user may change; if empty then all
+}
+
+// Users are the accounts of the controls UI.
+type Users struct {
+	list []*User
+}
+
+// LoadUsers reads the users document from the same kinds of sources as the plans document.
+func LoadUsers(source string) (*Users, error) {
+	data, err := NewPlanLoader(source).readPlansSource(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return ParseUsers(data)
+}
+
+// ParseUsers decodes and validates a users document, a YAML or JSON list of users.
+// Unknown fields are rejected.
+func ParseUsers(data []byte) (*Users, error) {
+	list := []*User{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid users document: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, each := range list {
+		if each == nil || each.Name == "" {
+			return nil, fmt.Errorf("user %d: missing name", i+1)
+		}
+		if seen[each.Name] {
+			return nil, fmt.Errorf("user %s: duplicate name", each.Name)
+		}
+		seen[each.Name] = true
+		if _, ok := roleLevels[each.Role]; !ok {
+			return nil, fmt.Errorf("user %s: unknown role %q, expected viewer, operator or admin", each.Name, each.Role)
+		}
+		if _, err := bcrypt.Cost([]byte(each.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %s: invalid password-hash: %w", each.Name, err)
+		}
+	}
+	return &Users{list: list}, nil
+}
+
+// HashPassword returns the bcrypt hash of a password for the users document.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// unknownUserHash is compared for an unknown user to take as long as for a known user.
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("moneypenny"), bcrypt.DefaultCost)
+
+// Authenticate returns the user with the name if the password matches its hash.
+func (u *Users) Authenticate(name, password string) (*User, bool) {
+	i := slices.IndexFunc(u.list, func(each *User) bool { return each.Name == name })
+	if i == -1 {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.list[i].PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+	return u.list[i], true
+}
+
+// requiredRole returns the role needed for an action (?do=) of the controls UI or an API route.
+func requiredRole(action string) string {
+	switch action {
+	case "start", "stop", "change-count", "scale", "snooze":
+		return RoleOperator
+	case "apply", "correct-drift":
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+// Can returns true if the user may do the action on the service; service is empty for actions on all services.
+// A user with clusters may only change services of these clusters and may not do actions on all services that require more than viewing.
+func (u *User) Can(action string, service Service) bool {
+	role := requiredRole(action)
+	if roleLevels[u.Role] < roleLevels[role] {
+		return false
+	}
+	if role == RoleViewer || len(u.Clusters) == 0 {
+		return true
+	}
+	if service.ARN == "" {
+		return false
+	}
+	return slices.Contains(u.Clusters, service.ClusterName())
+}
+
+type accountKey struct{}
+
+// accountFrom returns the authenticated user of the context, nil if authenticated otherwise or not at all.
+func accountFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(accountKey{}).(*User)
+	return user
+}
+
+// authorize returns true if the user of the request may do the action on the service, and logs a denial.
+// Requests without a user of the users document are not restricted.
+func authorize(r *http.Request, action string, service Service) bool {
+	user := accountFrom(r.Context())
+	if user == nil || user.Can(action, service) {
+		return true
+	}
+	slog.Warn("access denied", "user", user.Name, "role", user.Role, "action", action, "service", service.ARN, "required-role", requiredRole(action))
+	return false
+}
+
+// UsersAuth returns a handler that requires basic auth of one of the users and passes the user in the request context.
+func UsersAuth(users *Users, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, password, ok := r.BasicAuth()
+		user, valid := users.Authenticate(name, password)
+		if !ok || !valid {
+			slog.Warn("invalid credentials", "user", name, "pass-length", len(password))
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ctx := WithAccount(r.Context(), user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+var errForbidden = errors.New("your role does not allow this action")
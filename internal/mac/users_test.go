@@ -0,0 +1,118 @@
+package mac
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestUsers(t *testing.T) *Users {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	doc := fmt.Sprintf(`
+- name: viewer
+  password-hash: %[1]s
+  role: viewer
+- name: operator
+  password-hash: %[1]s
+  role: operator
+  clusters: [dev]
+- name: admin
+  password-hash: %[1]s
+  role: admin
+`, hash)
+	users, err := ParseUsers([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestParseUsersInvalid(t *testing.T) {
+	for _, each := range []string{
+		`[{"name":"a","password-hash":"plain","role":"admin"}]`,
+		`[{"name":"a","password-hash":"$2a$04$abcdefghijklmnopqrstuu5Ws1P0xqMWRZbTQHzeWcs5Ab8IXrzxC","role":"root"}]`,
+		`[{"password-hash":"$2a$04$abcdefghijklmnopqrstuu5Ws1P0xqMWRZbTQHzeWcs5Ab8IXrzxC","role":"admin"}]`,
+		`[{"name":"a","password":"secret","role":"admin"}]`,
+	} {
+		if _, err := ParseUsers([]byte(each)); err == nil {
+			t.Errorf("expected error for %s", each)
+		}
+	}
+}
+
+func TestUsersAuthenticate(t *testing.T) {
+	users := newTestUsers(t)
+	if _, ok := users.Authenticate("admin", "secret"); !ok {
+		t.Error("expected valid credentials")
+	}
+	if _, ok := users.Authenticate("admin", "wrong"); ok {
+		t.Error("expected invalid password")
+	}
+	if _, ok := users.Authenticate("bond", "secret"); ok {
+		t.Error("expected unknown user")
+	}
+}
+
+func TestUserCan(t *testing.T) {
+	users := newTestUsers(t)
+	dev := Service{ARN: testServiceARN}
+	prod := Service{ARN: "arn:aws:ecs:eu-central-1:9111111:service/prod/api"}
+	for _, each := range []struct {
+		user, action string
+		service      Service
+		want         bool
+	}{
+		{"viewer", "", Service{}, true},
+		{"viewer", "plan", Service{}, true},
+		{"viewer", "stop", dev, false},
+		{"operator", "stop", dev, true},
+		{"operator", "stop", prod, false},
+		{"operator", "scale", dev, true},
+		{"operator", "apply", Service{}, false},
+		{"admin", "apply", Service{}, true},
+		{"admin", "correct-drift", Service{}, true},
+		{"admin", "stop", prod, true},
+	} {
+		user, _ := users.Authenticate(each.user, "secret")
+		if got, want := user.Can(each.action, each.service), each.want; got != want {
+			t.Errorf("%s %s %s: got %v want %v", each.user, each.action, each.service.ARN, got, want)
+		}
+	}
+}
+
+func TestControlsHandlerDeniesByRole(t *testing.T) {
+	h, fake := newTestControlsHandler(t)
+	handler := UsersAuth(newTestUsers(t), h)
+	for _, each := range []struct {
+		user   string
+		status int
+	}{
+		{"viewer", http.StatusForbidden},
+		{"operator", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/services/"+testServiceARN+"/stop", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(each.user, "secret")
+		handler.ServeHTTP(rec, req)
+		if got, want := rec.Code, each.status; got != want {
+			t.Errorf("%s: got %v want %v", each.user, got, want)
+		}
+	}
+	if got, want := strings.Join(fake.updates, ","), "api=0"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	// the page action of a viewer
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?do=apply", nil)
+	req.SetBasicAuth("viewer", "secret")
+	handler.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
@@ -1,13 +1,42 @@
 package mac
 
 import (
+	"fmt"
 	"log/slog"
 	"slices"
+	"strings"
 	"time"
 )
 
 type WeekPlan struct {
-	Plans []*DayPlan `json:"plans"`
+	Plans      []*DayPlan  `json:"plans"`
+	Exceptions []time.Time `json:"exceptions,omitempty"` // dates on which no service is running, e.g. holidays
+}
+
+// ParseExceptionDates returns the dates of a comma separated list such as 2026-12-25,2026-12-26, in the user time zone.
+func ParseExceptionDates(value string) (list []time.Time, err error) {
+	for _, each := range strings.Split(value, ",") {
+		if strings.TrimSpace(each) == "" {
+			continue
+		}
+		date, err := time.ParseInLocation(time.DateOnly, strings.TrimSpace(each), userLocation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exception date %q, expected YYYY-MM-DD", each)
+		}
+		list = append(list, date)
+	}
+	return
+}
+
+// IsException returns whether when is on one of the exception dates.
+func (w WeekPlan) IsException(when time.Time) bool {
+	for _, each := range w.Exceptions {
+		y, m, d := when.In(each.Location()).Date()
+		if y == each.Year() && m == each.Month() && d == each.Day() {
+			return true
+		}
+	}
+	return false
 }
 
 func (w *WeekPlan) AddServicePlan(p ServicePlan) {
@@ -100,7 +129,29 @@ func witHourMinute(t time.Time, hour, minute int) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, t.Location())
 }
 
+// ScheduledCountAt returns the desired count of the plan at when, which is 0 on an exception date.
+func (w *WeekPlan) ScheduledCountAt(plan *ServicePlan, when time.Time) int {
+	if w != nil && w.IsException(when) {
+		return 0
+	}
+	return plan.DesiredCountAt(when)
+}
+
+// LastScheduledEventAt returns the last event of the service before when.
+// On an exception date, a running service must be stopped, as if stopped at the start of that day.
 func (w WeekPlan) LastScheduledEventAt(service Service, when time.Time) (ScheduledEvent, bool) {
+	event, ok := w.lastScheduledEventAt(service, when)
+	if ok && event.DesiredState == Running && w.IsException(when) {
+		y, m, d := when.Date()
+		event.DesiredState, event.DesiredCount = Stopped, 0
+		if midnight := time.Date(y, m, d, 0, 0, 0, 0, when.Location()); midnight.After(event.At) {
+			event.At = midnight
+		}
+	}
+	return event, ok
+}
+
+func (w WeekPlan) lastScheduledEventAt(service Service, when time.Time) (ScheduledEvent, bool) {
 	wkd := when.Weekday()
 	event := ScheduledEvent{}
 	for _, dp := range w.Plans {
@@ -122,3 +173,105 @@ func (w WeekPlan) LastScheduledEventAt(service Service, when time.Time) (Schedul
 	}
 	return event, !event.At.IsZero()
 }
+
+const minutesPerDay = 24 * 60
+const minutesPerWeek = 7 * minutesPerDay
+
+// RunningWindow is a period in the week in which a service is running.
+// Start and End are minutes since Sunday 00:00; End is exclusive.
+type RunningWindow struct {
+	Start, End   int
+	DesiredCount int
+}
+
+// Services returns all services with a plan, sorted by cluster and name.
+func (w *WeekPlan) Services() (list []Service) {
+	seen := map[string]bool{}
+	w.TimePlansDo(func(tp *TimePlan) {
+		if !seen[tp.ARN] {
+			seen[tp.ARN] = true
+			list = append(list, tp.Service)
+		}
+	})
+	slices.SortFunc(list, func(a, b Service) int {
+		return strings.Compare(a.ClusterName()+"/"+a.Name(), b.ClusterName()+"/"+b.Name())
+	})
+	return
+}
+
+// RunningWindows returns the periods in the week in which the service is running, ordered by start.
+// The state at the start of the week is the state set by the last change of the week.
+// A window that is running at the end of the week is not joined with the one at the start.
+func (w *WeekPlan) RunningWindows(service Service) (list []RunningWindow) {
+	type change struct {
+		minute int
+		tp     *TimePlan
+	}
+	changes := []change{}
+	for _, dp := range w.Plans {
+		for _, tp := range dp.Plans {
+			if tp.ARN == service.ARN {
+				changes = append(changes, change{minute: int(dp.Weekday)*minutesPerDay + tp.Hour*60 + tp.Minute, tp: tp})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	slices.SortStableFunc(changes, func(a, b change) int { return intCompare(a.minute, b.minute) })
+	// state at start of week
+	last := changes[len(changes)-1].tp
+	running, count, start := last.DesiredState == Running, last.DesiredCount, 0
+	for _, each := range changes {
+		isRunning := each.tp.DesiredState == Running
+		if running && (!isRunning || each.tp.DesiredCount != count) {
+			if each.minute > start {
+				list = append(list, RunningWindow{Start: start, End: each.minute, DesiredCount: count})
+			}
+		}
+		if isRunning && (!running || each.tp.DesiredCount != count) {
+			start = each.minute
+		}
+		running, count = isRunning, each.tp.DesiredCount
+	}
+	if running && start < minutesPerWeek {
+		list = append(list, RunningWindow{Start: start, End: minutesPerWeek, DesiredCount: count})
+	}
+	return
+}
+
+// NextStopAfter returns the time of the first scheduled stop of the service after when, within a week.
+func (w *WeekPlan) NextStopAfter(service Service, when time.Time) (time.Time, bool) {
+	event, ok := w.nextEventAfter(service, when, func(tp *TimePlan) bool { return tp.DesiredState == Stopped })
+	return event.At, ok
+}
+
+// NextEventAfter returns the first scheduled event of the service after when, within a week.
+func (w *WeekPlan) NextEventAfter(service Service, when time.Time) (ScheduledEvent, bool) {
+	return w.nextEventAfter(service, when, func(tp *TimePlan) bool { return true })
+}
+
+func (w *WeekPlan) nextEventAfter(service Service, when time.Time, match func(tp *TimePlan) bool) (ScheduledEvent, bool) {
+	for d := 0; d <= 7; d++ {
+		day := when.AddDate(0, 0, d)
+		next := ScheduledEvent{}
+		for _, dp := range w.Plans {
+			if dp.Weekday != day.Weekday() {
+				continue
+			}
+			for _, tp := range dp.Plans {
+				if tp.ARN != service.ARN || !match(tp) {
+					continue
+				}
+				at := witHourMinute(day, tp.Hour, tp.Minute)
+				if at.After(when) && (next.At.IsZero() || at.Before(next.At)) {
+					next = ScheduledEvent{Service: tp.Service, DesiredState: tp.DesiredState, DesiredCount: tp.DesiredCount, At: at}
+				}
+			}
+		}
+		if !next.At.IsZero() {
+			return next, true
+		}
+	}
+	return ScheduledEvent{}, false
+}
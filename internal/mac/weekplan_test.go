@@ -21,3 +21,42 @@ func TestLastScheduledEventAtOnlyStopped(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestLastScheduledEventAtException(t *testing.T) {
+	svc := Service{ARN: "test"}
+	sp := ServicePlan{Service: svc, TagValue: "running=0 8 1-5. stopped=0 18 1-5."}
+	sp.Validate()
+	wp := new(WeekPlan)
+	wp.AddServicePlan(sp)
+	wp.Exceptions, _ = ParseExceptionDates("2024-04-01")
+
+	monday := time.Date(2024, 4, 1, 12, 0, 0, 0, userLocation)
+	ev, ok := wp.LastScheduledEventAt(svc, monday)
+	if !ok || ev.DesiredState != Stopped || ev.DesiredCount != 0 {
+		t.Errorf("got %v want stopped", ev)
+	}
+	// the start at 08:00 is replaced by a stop
+	if got, want := ev.At, time.Date(2024, 4, 1, 8, 0, 0, 0, userLocation); !got.Equal(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	ev, _ = wp.LastScheduledEventAt(svc, monday.AddDate(0, 0, 1))
+	if ev.DesiredState != Running {
+		t.Errorf("got %v want running", ev)
+	}
+}
+
+func TestParseExceptionDates(t *testing.T) {
+	list, err := ParseExceptionDates("2026-12-25, 2026-12-26")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(list), 2; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := list[1].Day(), 26; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if _, err := ParseExceptionDates("25-12-2026"); err == nil {
+		t.Error("error expected")
+	}
+}